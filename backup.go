@@ -0,0 +1,448 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "os"
+import "fmt"
+import "sort"
+import "time"
+import "errors"
+import "regexp"
+import "strings"
+import "io/ioutil"
+import "encoding/json"
+
+var backupNotFoundError = errors.New("No backup with that ID.")
+
+// saveDoneRe matches the message the game logs when it finishes saving the world.
+var saveDoneRe = regexp.MustCompile(`(?i)(game|world) (data )?saved|save(d)? (done|complete)`)
+
+const saveTimeout = 60 * time.Second
+
+// BackupInfo describes a backup, it is stored in a JSON file next to the backup archive.
+type BackupInfo struct {
+	ID      string
+	SID     int
+	Name    string // The server name when the backup was made.
+	Version string // The game version the server was using.
+	At      time.Time
+	By      string // Who asked for the backup.
+	Size    int64
+	Online  bool // Was the server running when the backup was made?
+
+	Scheduled bool // Was this made by the backup schedule? Only scheduled backups are pruned.
+}
+
+func (b *BackupInfo) String() string {
+	online := ""
+	if b.Online {
+		online = ", online"
+	}
+	return fmt.Sprintf("%v: %v, version %v, %.1fMB, by %v%v", b.ID, b.At.Format("2006-01-02 15:04:05"),
+		b.Version, float64(b.Size)/(1024*1024), b.By, online)
+}
+
+// backupDir returns the directory backups for the given server are stored in.
+func (c *MonitorConfig) backupDir(sid int) string {
+	c.RLock()
+	dir := c.BackupDir
+	c.RUnlock()
+	if dir == "" {
+		dir = baseDir() + "/Backups"
+	}
+	return fmt.Sprintf("%v/%v", dir, sid)
+}
+
+// CreateBackup writes a compressed copy of a server's data directory to the backup directory. If the server
+// is running it is told to save first.
+func (c *MonitorConfig) CreateBackup(sid int, by string, scheduled bool) (*BackupInfo, error) {
+	c.RLock()
+	sd, ok := c.Servers[sid]
+	sc := c.LaunchedHandlers[sid]
+	dat := c.DataDir
+	c.RUnlock()
+	if !ok {
+		return nil, invalidSIDError
+	}
+
+	sd.RLock()
+	info := &BackupInfo{
+		SID:     sid,
+		Name:    sd.Name,
+		Version: sd.Version,
+		By:      by,
+
+		Scheduled: scheduled,
+	}
+	sd.RUnlock()
+	dat += fmt.Sprintf("/%v %v", info.Name, sid)
+
+	if sc != nil && sc.IsUp() {
+		info.Online = true
+		w := sc.expect(saveDoneRe)
+		if sc.Command("/autosavenow") && !w.wait(saveTimeout) {
+			sc.log("Server did not report finishing the save in time, backing up anyway.")
+		}
+		sc.unexpect(w)
+	}
+
+	dir := c.backupDir(sid)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	info.At = time.Now()
+	info.ID = info.At.Format("20060102-150405")
+	for i := 2; ; i++ {
+		if _, err := os.Stat(dir + "/" + info.ID + ".tar.gz"); os.IsNotExist(err) {
+			break
+		}
+		info.ID = fmt.Sprintf("%v-%v", info.At.Format("20060102-150405"), i)
+	}
+
+	// Write to a temporary file first so a failed backup never looks like a good one.
+	tmp := dir + "/" + info.ID + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	err = CreateTarGz(f, dat, func(rel string) bool {
+		return rel == strings.TrimPrefix(pidFileName, "/")
+	})
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	st, err := f.Stat()
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	info.Size = st.Size()
+
+	content, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	err = ioutil.WriteFile(dir+"/"+info.ID+".json", content, 0644)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	err = os.Rename(tmp, dir+"/"+info.ID+".tar.gz")
+	if err != nil {
+		os.Remove(dir + "/" + info.ID + ".json")
+		os.Remove(tmp)
+		return nil, err
+	}
+	return info, nil
+}
+
+// ListBackups returns all the backups for a server, oldest first.
+func (c *MonitorConfig) ListBackups(sid int) ([]*BackupInfo, error) {
+	dir := c.backupDir(sid)
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*BackupInfo{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		content, err := ioutil.ReadFile(dir + "/" + file.Name())
+		if err != nil {
+			return nil, err
+		}
+		info := new(BackupInfo)
+		err = json.Unmarshal(content, info)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].At.Before(out[j].At)
+	})
+	return out, nil
+}
+
+// validBackupID makes sure a user supplied ID cannot be used to reach outside the backup directory.
+func validBackupID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/\\.")
+}
+
+// RestoreBackup replaces a server's data directory with the contents of a backup. The server must be down.
+// A backup of the current data is made first, just in case. If the controller is waiting to restart the server
+// after a crash, the restart is held off until the restore is finished.
+func (c *MonitorConfig) RestoreBackup(sid int, id string, by string) error {
+	c.RLock()
+	sd, ok := c.Servers[sid]
+	sc := c.LaunchedHandlers[sid]
+	dat := c.DataDir
+	c.RUnlock()
+	if !ok {
+		return invalidSIDError
+	}
+	if sc != nil {
+		sc.dmu.Lock()
+		defer sc.dmu.Unlock()
+		if sc.IsUp() {
+			return serverUpError
+		}
+	}
+
+	dir := c.backupDir(sid)
+	if !validBackupID(id) {
+		return backupNotFoundError
+	}
+	f, err := os.Open(dir + "/" + id + ".tar.gz")
+	if os.IsNotExist(err) {
+		return backupNotFoundError
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = c.CreateBackup(sid, by+" (before restoring "+id+")", false)
+	if err != nil {
+		return err
+	}
+
+	sd.RLock()
+	dat += fmt.Sprintf("/%v %v", sd.Name, sid)
+	sd.RUnlock()
+
+	err = removeContents(dat)
+	if err != nil {
+		return err
+	}
+	return ExtractTarGz(f, dat)
+}
+
+// DeleteBackup removes a backup.
+func (c *MonitorConfig) DeleteBackup(sid int, id string) error {
+	dir := c.backupDir(sid)
+	if !validBackupID(id) {
+		return backupNotFoundError
+	}
+	err := os.Remove(dir + "/" + id + ".tar.gz")
+	if os.IsNotExist(err) {
+		return backupNotFoundError
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(dir + "/" + id + ".json")
+}
+
+// scheduledBy is used as the BackupInfo.By value for scheduled backups. It is only for display, since anyone
+// could have a user with that name, BackupInfo.Scheduled is what marks a backup as one that may be pruned.
+const scheduledBy = "schedule"
+
+// BackupSchedule controls automatic backups for a server. If all the Keep values are zero, old scheduled
+// backups are never pruned. Otherwise a scheduled backup is kept if it is one of the KeepLast newest, or
+// the newest backup in one of the KeepHourly newest hours, KeepDaily newest days, or KeepWeekly newest
+// weeks that have backups. Backups made by hand are never pruned.
+type BackupSchedule struct {
+	Spec string // See Schedule for the format. Empty means no scheduled backups.
+
+	KeepLast   int
+	KeepHourly int
+	KeepDaily  int
+	KeepWeekly int
+
+	LastRun time.Time
+}
+
+func (b BackupSchedule) String() string {
+	if b.Spec == "" {
+		return "No scheduled backups."
+	}
+	if b.KeepLast == 0 && b.KeepHourly == 0 && b.KeepDaily == 0 && b.KeepWeekly == 0 {
+		return fmt.Sprintf("Backups scheduled for %q, keeping all backups.", b.Spec)
+	}
+	return fmt.Sprintf("Backups scheduled for %q, keeping the last %v, %v hourly, %v daily, and %v weekly.",
+		b.Spec, b.KeepLast, b.KeepHourly, b.KeepDaily, b.KeepWeekly)
+}
+
+// Prune returns the scheduled backups from the list that fall outside the retention rules.
+func (b BackupSchedule) Prune(backups []*BackupInfo) []*BackupInfo {
+	if b.KeepLast == 0 && b.KeepHourly == 0 && b.KeepDaily == 0 && b.KeepWeekly == 0 {
+		return nil
+	}
+
+	scheduled := []*BackupInfo{}
+	for _, info := range backups {
+		if info.Scheduled {
+			scheduled = append(scheduled, info)
+		}
+	}
+	sort.Slice(scheduled, func(i, j int) bool {
+		return scheduled[i].At.After(scheduled[j].At)
+	})
+
+	keep := map[*BackupInfo]bool{}
+	for i := 0; i < b.KeepLast && i < len(scheduled); i++ {
+		keep[scheduled[i]] = true
+	}
+	buckets := func(n int, key func(t time.Time) string) {
+		seen := map[string]bool{}
+		for _, info := range scheduled {
+			if len(seen) >= n {
+				return
+			}
+			k := key(info.At)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			keep[info] = true
+		}
+	}
+	buckets(b.KeepHourly, func(t time.Time) string { return t.Format("2006010215") })
+	buckets(b.KeepDaily, func(t time.Time) string { return t.Format("20060102") })
+	buckets(b.KeepWeekly, func(t time.Time) string {
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%v-%v", y, w)
+	})
+
+	out := []*BackupInfo{}
+	for _, info := range scheduled {
+		if !keep[info] {
+			out = append(out, info)
+		}
+	}
+	return out
+}
+
+// BackupSettings returns the backup schedule for this server.
+func (sd *ServerConfig) BackupSettings() BackupSchedule {
+	sd.RLock()
+	defer sd.RUnlock()
+
+	if sd.Backups == nil {
+		return BackupSchedule{}
+	}
+	return *sd.Backups
+}
+
+// backupScheduler runs scheduled backups. It never returns.
+func (c *MonitorConfig) backupScheduler() {
+	running := map[int]bool{}
+	finished := make(chan int)
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case sid := <-finished:
+			delete(running, sid)
+		case now := <-ticker.C:
+			c.RLock()
+			due := []int{}
+			for sid, sd := range c.Servers {
+				if running[sid] {
+					continue
+				}
+				// Skip halted servers, their controller is gone and there is nobody to report to.
+				sc, ok := c.LaunchedHandlers[sid]
+				if !ok || !sc.IsAlive() {
+					continue
+				}
+				b := sd.BackupSettings()
+				if b.Spec == "" {
+					continue
+				}
+				sched, err := ParseSchedule(b.Spec)
+				if err != nil {
+					continue
+				}
+				next := sched.Next(b.LastRun)
+				if next.IsZero() || now.Before(next) {
+					continue
+				}
+				due = append(due, sid)
+			}
+			c.RUnlock()
+
+			for _, sid := range due {
+				running[sid] = true
+				go func(sid int) {
+					c.scheduledBackup(sid)
+					finished <- sid
+				}(sid)
+			}
+		}
+	}
+}
+
+// scheduledBackup makes a scheduled backup of a server and then prunes old backups.
+func (c *MonitorConfig) scheduledBackup(sid int) {
+	c.RLock()
+	sd, ok := c.Servers[sid]
+	sc, launched := c.LaunchedHandlers[sid]
+	c.RUnlock()
+	if !ok || !launched || !sc.IsAlive() {
+		return
+	}
+
+	// Record the run first, so a backup that fails is not retried every tick.
+	sd.Lock()
+	if sd.Backups != nil {
+		sd.Backups.LastRun = time.Now()
+	}
+	sd.Unlock()
+	c.Dump()
+
+	sc.log("Starting scheduled backup...")
+	info, err := c.CreateBackup(sid, scheduledBy, true)
+	if err != nil {
+		sc.log("Scheduled backup failed: %v", err)
+		return
+	}
+	sc.log("Scheduled backup finished: %v", info)
+
+	backups, err := c.ListBackups(sid)
+	if err != nil {
+		sc.log("Could not prune old backups: %v", err)
+		return
+	}
+	for _, old := range sd.BackupSettings().Prune(backups) {
+		err := c.DeleteBackup(sid, old.ID)
+		if err != nil {
+			sc.log("Could not delete old backup %v: %v", old.ID, err)
+			continue
+		}
+		sc.log("Deleted old backup %v.", old.ID)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "sort"
+import "time"
+import "testing"
+
+func TestBackupPrune(t *testing.T) {
+	// Scheduled backups every 6 hours from Friday May 1st to Friday May 15th, plus a few manual backups
+	// which must never be pruned, even if they were made by a user with the same name as the schedule.
+	backups := []*BackupInfo{}
+	start := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	for at := start; at.Before(start.AddDate(0, 0, 15)); at = at.Add(6 * time.Hour) {
+		backups = append(backups, &BackupInfo{ID: at.Format("01-02 15h"), At: at, By: scheduledBy, Scheduled: true})
+	}
+	for _, day := range []int{1, 7, 15} {
+		at := time.Date(2026, 5, day, 20, 0, 0, 0, time.UTC)
+		backups = append(backups, &BackupInfo{ID: "manual " + at.Format("01-02"), At: at, By: "admin"})
+		at = at.Add(time.Hour)
+		backups = append(backups, &BackupInfo{ID: "user " + at.Format("01-02"), At: at, By: scheduledBy})
+	}
+
+	tests := []struct {
+		name  string
+		keep  BackupSchedule
+		count int      // How many scheduled backups are kept.
+		kept  []string // IDs of the kept backups, oldest first. Not checked if nil.
+	}{
+		{"no retention", BackupSchedule{}, 60, nil},
+		{"last", BackupSchedule{KeepLast: 3}, 3, []string{"05-15 06h", "05-15 12h", "05-15 18h"}},
+		{"more than there are", BackupSchedule{KeepLast: 100}, 60, nil},
+		{"hourly", BackupSchedule{KeepHourly: 2}, 2, []string{"05-15 12h", "05-15 18h"}},
+		{"daily", BackupSchedule{KeepDaily: 3}, 3, []string{"05-13 18h", "05-14 18h", "05-15 18h"}},
+		{"weekly", BackupSchedule{KeepWeekly: 2}, 2, []string{"05-10 18h", "05-15 18h"}},
+		{"weekly, fewer weeks than asked for", BackupSchedule{KeepWeekly: 10}, 3, []string{"05-03 18h", "05-10 18h", "05-15 18h"}},
+		{"last and daily overlap", BackupSchedule{KeepLast: 2, KeepDaily: 3}, 4, []string{"05-13 18h", "05-14 18h", "05-15 12h", "05-15 18h"}},
+		{"daily and weekly", BackupSchedule{KeepDaily: 2, KeepWeekly: 3}, 4, []string{"05-03 18h", "05-10 18h", "05-14 18h", "05-15 18h"}},
+	}
+	for _, test := range tests {
+		pruned := map[*BackupInfo]bool{}
+		for _, info := range test.keep.Prune(backups) {
+			if !info.Scheduled {
+				t.Errorf("%v: pruned manual backup %v", test.name, info.ID)
+			}
+			pruned[info] = true
+		}
+
+		kept := []*BackupInfo{}
+		for _, info := range backups {
+			if info.Scheduled && !pruned[info] {
+				kept = append(kept, info)
+			}
+		}
+		if len(kept) != test.count {
+			t.Errorf("%v: kept %v backups, expected %v", test.name, len(kept), test.count)
+			continue
+		}
+		if test.kept == nil {
+			continue
+		}
+		sort.Slice(kept, func(i, j int) bool { return kept[i].At.Before(kept[j].At) })
+		for i, info := range kept {
+			if info.ID != test.kept[i] {
+				t.Errorf("%v: kept backup %v is %v, expected %v", test.name, i, info.ID, test.kept[i])
+			}
+		}
+	}
+}
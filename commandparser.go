@@ -36,8 +36,12 @@ func parseCommand(in []byte) []string {
 			continue
 		}
 		if b == '"' {
+			// Opening a quote only ends the previous argument if there is one, otherwise two quoted
+			// arguments in a row would have an empty one between them.
+			if quotes || len(buf) > 0 {
+				out = append(out, string(buf))
+			}
 			quotes = !quotes
-			out = append(out, string(buf))
 			buf = nil
 			continue
 		}
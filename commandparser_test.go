@@ -0,0 +1,60 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "reflect"
+import "testing"
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		in  string
+		out []string
+	}{
+		{``, []string{}},
+		{`   `, []string{}},
+		{`:stop`, []string{":stop"}},
+		{`:restart in 10m`, []string{":restart", "in", "10m"}},
+		{"  :log \t more  ", []string{":log", "more"}},
+		{`:user create "Bob Smith"`, []string{":user", "create", "Bob Smith"}},
+		{`:server launch args ""`, []string{":server", "launch", "args", ""}},
+
+		// Quoted arguments next to each other or to unquoted ones.
+		{`"a""b"`, []string{"a", "b"}},
+		{`"a" "b"`, []string{"a", "b"}},
+		{`"""a"`, []string{"", "a"}},
+		{`:schedule add "0 5 * * *" ":restart"`, []string{":schedule", "add", "0 5 * * *", ":restart"}},
+		{`:schedule add "@every 2h""/announce hi"`, []string{":schedule", "add", "@every 2h", "/announce hi"}},
+		{`x"y"`, []string{"x", "y"}},
+		{`"x"y`, []string{"x", "y"}},
+		{`a"b c"d`, []string{"a", "b c", "d"}},
+
+		// An unterminated quote runs to the end of the line.
+		{`:user create "Bob`, []string{":user", "create", "Bob"}},
+	}
+	for _, test := range tests {
+		out := parseCommand([]byte(test.in))
+		if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("%q: got %q, expected %q", test.in, out, test.out)
+		}
+	}
+}
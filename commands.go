@@ -25,8 +25,10 @@ package main
 import "os"
 import "fmt"
 import "time"
+import "sort"
+import "regexp"
 import "strconv"
-import "crypto/rand"
+import "strings"
 
 import "github.com/gorilla/websocket"
 
@@ -49,11 +51,94 @@ func cmdRecover(conn *websocket.Conn, usr *MonitorUser, args []string, sid int)
 	}
 }
 
+func helpStop(conn *websocket.Conn, sid int) {
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":stop"})
+}
+
+func cmdStop(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
+	GlobalConfig.RLock()
+	sc, ok := GlobalConfig.LaunchedHandlers[sid]
+	GlobalConfig.RUnlock()
+	if !ok {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not stop server, invalid SID."})
+		return
+	}
+	sc.CancelRestart()
+	ok = sc.Stop(false)
+	if !ok {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not stop server, server not up."})
+		return
+	}
+}
+
+func helpRestart(conn *websocket.Conn, sid int) {
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":restart"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":restart in <duration>"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":restart cancel"})
+}
+
+func cmdRestart(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
+	GlobalConfig.RLock()
+	sc, ok := GlobalConfig.LaunchedHandlers[sid]
+	GlobalConfig.RUnlock()
+	if !ok {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not restart server, invalid SID."})
+		return
+	}
+
+	if len(args) > 1 {
+		switch args[1] {
+		case "in":
+			if len(args) < 3 {
+				helpRestart(conn, sid)
+				return
+			}
+			d, err := time.ParseDuration(args[2])
+			if err != nil || d < time.Second {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not restart server, invalid duration."})
+				return
+			}
+			if !sc.RestartIn(d) {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not restart server, server not up."})
+				return
+			}
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Server will restart in " + d.String() + "."})
+		case "cancel":
+			if !sc.CancelRestart() {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "No restart is pending."})
+				return
+			}
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Pending restart cancelled."})
+		default:
+			helpRestart(conn, sid)
+		}
+		return
+	}
+
+	sc.CancelRestart()
+	ok = sc.Stop(true)
+	if !ok {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not restart server, server not up."})
+		return
+	}
+}
+
 func helpServer(conn *websocket.Conn, sid int) {
 	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server create \"<name>\" [stable|unstable|<x.x.x.x>]"})
 	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server update  [<x.x.x.x>]"})
 	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server rename \"<name>\""})
-	//GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server delete"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server autostart [on|off]"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server policy [always|never|reset]"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server policy (restarts|window|delay|maxdelay) <value>"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server watchdog [on|off|reset]"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server watchdog (interval|timeout) <seconds>"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server watchdog probe \"<command>\""})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server launch [reset]"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server launch (exe|dir) \"<value>\""})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server launch (args|env) [\"<value>\" ...]"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server limits [reset]"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server limits (nice|addressspace|openfiles|memory|cpu) <value>"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":server delete \"<name>\" [archive|remove]"})
 }
 
 func cmdServer(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
@@ -162,14 +247,797 @@ func cmdServer(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
 			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not move server config directory: " + err.Error()})
 			return
 		}
+	case "autostart":
+		GlobalConfig.RLock()
+		sc, ok := GlobalConfig.Servers[sid]
+		GlobalConfig.RUnlock()
+		if !ok {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not update server, invalid SID."})
+			return
+		}
+		if len(args) >= 3 {
+			switch args[2] {
+			case "on":
+				sc.Lock()
+				sc.AutoStart = true
+				sc.Unlock()
+			case "off":
+				sc.Lock()
+				sc.AutoStart = false
+				sc.Unlock()
+			default:
+				helpServer(conn, sid)
+				return
+			}
+			GlobalConfig.Dump()
+		}
+		sc.RLock()
+		autostart := sc.AutoStart
+		sc.RUnlock()
+		if autostart {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Server will be started when the monitor launches."})
+		} else {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Server will not be started when the monitor launches."})
+		}
+	case "policy":
+		GlobalConfig.RLock()
+		sc, ok := GlobalConfig.Servers[sid]
+		GlobalConfig.RUnlock()
+		if !ok {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not update server, invalid SID."})
+			return
+		}
+		if len(args) >= 3 {
+			policy := sc.Policy()
+			switch args[2] {
+			case "always":
+				policy.Never = false
+			case "never":
+				policy.Never = true
+			case "reset":
+				policy = DefaultRestartPolicy
+			case "restarts", "window", "delay", "maxdelay":
+				if len(args) < 4 {
+					helpServer(conn, sid)
+					return
+				}
+				v, err := strconv.Atoi(args[3])
+				if err != nil || v < 0 {
+					GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not update policy, invalid value."})
+					return
+				}
+				switch args[2] {
+				case "restarts":
+					policy.MaxRestarts = v
+				case "window":
+					policy.Window = v
+				case "delay":
+					policy.Delay = v
+				case "maxdelay":
+					policy.MaxDelay = v
+				}
+			default:
+				helpServer(conn, sid)
+				return
+			}
+			sc.Lock()
+			sc.Restart = &policy
+			sc.Unlock()
+			GlobalConfig.Dump()
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, sc.Policy().String()})
+	case "watchdog":
+		GlobalConfig.RLock()
+		sc, ok := GlobalConfig.Servers[sid]
+		GlobalConfig.RUnlock()
+		if !ok {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not update server, invalid SID."})
+			return
+		}
+		if len(args) >= 3 {
+			wd := sc.WatchdogConfig()
+			switch args[2] {
+			case "on":
+				wd.Enabled = true
+			case "off":
+				wd.Enabled = false
+			case "reset":
+				wd = DefaultWatchdog
+			case "interval", "timeout":
+				if len(args) < 4 {
+					helpServer(conn, sid)
+					return
+				}
+				v, err := strconv.Atoi(args[3])
+				if err != nil || v <= 0 {
+					GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not update watchdog, invalid value."})
+					return
+				}
+				if args[2] == "interval" {
+					wd.Interval = v
+				} else {
+					wd.Timeout = v
+				}
+			case "probe":
+				if len(args) < 4 {
+					helpServer(conn, sid)
+					return
+				}
+				wd.Probe = args[3]
+			default:
+				helpServer(conn, sid)
+				return
+			}
+			if err := wd.validate(); err != nil {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not update watchdog: " + err.Error()})
+				return
+			}
+			sc.Lock()
+			sc.Watchdog = &wd
+			sc.Unlock()
+			GlobalConfig.Dump()
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Watchdog changes take effect the next time the server starts."})
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, sc.WatchdogConfig().String()})
+	case "launch":
+		GlobalConfig.RLock()
+		sc, ok := GlobalConfig.Servers[sid]
+		GlobalConfig.RUnlock()
+		if !ok {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not update server, invalid SID."})
+			return
+		}
+		if len(args) >= 3 {
+			// Being able to set the executable means being able to run anything on the host.
+			if !usr.IsAdmin {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Changing launch settings is a admin only action."})
+				return
+			}
+			sc.RLock()
+			launch := LaunchConfig{}
+			if sc.Launch != nil {
+				launch = *sc.Launch
+			}
+			sc.RUnlock()
+			switch args[2] {
+			case "reset":
+				launch = LaunchConfig{}
+			case "exe", "dir":
+				if len(args) < 4 {
+					helpServer(conn, sid)
+					return
+				}
+				if args[2] == "exe" {
+					launch.Executable = args[3]
+				} else {
+					launch.WorkDir = args[3]
+				}
+			case "args":
+				launch.Args = append([]string{}, args[3:]...)
+				if len(launch.Args) == 0 {
+					launch.Args = nil
+				}
+			case "env":
+				launch.Env = append([]string{}, args[3:]...)
+			default:
+				helpServer(conn, sid)
+				return
+			}
+			sc.Lock()
+			sc.Launch = &launch
+			sc.Unlock()
+			GlobalConfig.Dump()
+		}
+		launch, err := GlobalConfig.LaunchSettings(sid)
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, err.Error()})
+			return
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Command: " + launch.String()})
+		if len(launch.Env) > 0 {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Environment: " + strings.Join(launch.Env, " ")})
+		}
+		if launch.WorkDir != "" {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Working directory: " + launch.WorkDir})
+		}
+	case "limits":
+		GlobalConfig.RLock()
+		sc, ok := GlobalConfig.Servers[sid]
+		GlobalConfig.RUnlock()
+		if !ok {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not update server, invalid SID."})
+			return
+		}
+		if len(args) >= 3 {
+			if !usr.IsAdmin {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Changing resource limits is a admin only action."})
+				return
+			}
+			limits := sc.LimitSettings()
+			switch args[2] {
+			case "reset":
+				limits = ResourceLimits{}
+			case "nice", "addressspace", "openfiles", "memory", "cpu":
+				if len(args) < 4 {
+					helpServer(conn, sid)
+					return
+				}
+				v, err := strconv.Atoi(args[3])
+				if err != nil || (args[2] != "nice" && v < 0) || (args[2] == "nice" && (v < -20 || v > 19)) {
+					GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not update limits, invalid value."})
+					return
+				}
+				switch args[2] {
+				case "nice":
+					limits.Nice = v
+				case "addressspace":
+					limits.AddressSpace = v
+				case "openfiles":
+					limits.OpenFiles = v
+				case "memory":
+					limits.MemoryMax = v
+				case "cpu":
+					limits.CPUMax = v
+				}
+			default:
+				helpServer(conn, sid)
+				return
+			}
+			sc.Lock()
+			sc.Limits = &limits
+			sc.Unlock()
+			GlobalConfig.Dump()
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Resource limit changes take effect the next time the server starts."})
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, sc.LimitSettings().String()})
 	case "delete":
-		// TODO: Cannot cleanly shutdown halted servers right now.
-		fallthrough
+		if !usr.IsAdmin {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Deleting servers is a admin only action."})
+			return
+		}
+		// The name is required to make it harder to delete the wrong server by accident.
+		if len(args) < 3 {
+			helpServer(conn, sid)
+			return
+		}
+		archive := true
+		if len(args) >= 4 {
+			switch args[3] {
+			case "archive":
+			case "remove":
+				archive = false
+			default:
+				helpServer(conn, sid)
+				return
+			}
+		}
+		GlobalConfig.RLock()
+		sc, ok := GlobalConfig.Servers[sid]
+		GlobalConfig.RUnlock()
+		if !ok {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not delete server, invalid SID."})
+			return
+		}
+		sc.RLock()
+		name := sc.Name
+		sc.RUnlock()
+		if name != args[2] {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not delete server, name does not match."})
+			return
+		}
+
+		err := GlobalConfig.DeleteServer(sid, archive)
+		if err == serverUpError {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not delete server, server is running. Use :stop first."})
+			return
+		}
+		GlobalSockets.Broadcast(&LogMessage{sid, time.Now(), RemoveClass, name})
+		GlobalConfig.Dump()
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{0, time.Now(), ErrorClass, "Server deleted, but its data directory could not be cleaned up: " + err.Error()})
+			return
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{0, time.Now(), MonitorClass, "Server \"" + name + "\" deleted."})
 	default:
 		helpServer(conn, sid)
 	}
 }
 
+func helpBackup(conn *websocket.Conn, sid int) {
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":backup (create|list)"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":backup (restore|delete) <id>"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":backup schedule [\"<cron>\"|\"@every <duration>\"|off]"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":backup keep (last|hourly|daily|weekly) <count>"})
+}
+
+func cmdBackup(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
+	if len(args) < 2 {
+		helpBackup(conn, sid)
+		return
+	}
+	switch args[1] {
+	case "create":
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Creating backup..."})
+		info, err := GlobalConfig.CreateBackup(sid, usr.Name, false)
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not create backup: " + err.Error()})
+			return
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Backup created: " + info.String()})
+	case "list":
+		backups, err := GlobalConfig.ListBackups(sid)
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not list backups: " + err.Error()})
+			return
+		}
+		if len(backups) == 0 {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "No backups."})
+			return
+		}
+		for _, info := range backups {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, info.String()})
+		}
+	case "restore":
+		if len(args) < 3 {
+			helpBackup(conn, sid)
+			return
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Restoring backup..."})
+		err := GlobalConfig.RestoreBackup(sid, args[2], usr.Name)
+		if err == serverUpError {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not restore backup, server is running. Use :stop first."})
+			return
+		}
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not restore backup: " + err.Error()})
+			return
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Backup restored, use :recover to start the server."})
+	case "delete":
+		if len(args) < 3 {
+			helpBackup(conn, sid)
+			return
+		}
+		err := GlobalConfig.DeleteBackup(sid, args[2])
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not delete backup: " + err.Error()})
+			return
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Backup deleted."})
+	case "schedule", "keep":
+		GlobalConfig.RLock()
+		sc, ok := GlobalConfig.Servers[sid]
+		GlobalConfig.RUnlock()
+		if !ok {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not update server, invalid SID."})
+			return
+		}
+		if len(args) >= 3 {
+			b := sc.BackupSettings()
+			if args[1] == "schedule" {
+				if args[2] == "off" {
+					b.Spec = ""
+				} else {
+					_, err := ParseSchedule(args[2])
+					if err != nil {
+						GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, err.Error()})
+						return
+					}
+					b.Spec = args[2]
+					b.LastRun = time.Now()
+				}
+			} else {
+				if len(args) < 4 {
+					helpBackup(conn, sid)
+					return
+				}
+				v, err := strconv.Atoi(args[3])
+				if err != nil || v < 0 {
+					GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not update backup schedule, invalid count."})
+					return
+				}
+				switch args[2] {
+				case "last":
+					b.KeepLast = v
+				case "hourly":
+					b.KeepHourly = v
+				case "daily":
+					b.KeepDaily = v
+				case "weekly":
+					b.KeepWeekly = v
+				default:
+					helpBackup(conn, sid)
+					return
+				}
+			}
+			sc.Lock()
+			sc.Backups = &b
+			sc.Unlock()
+			GlobalConfig.Dump()
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, sc.BackupSettings().String()})
+	default:
+		helpBackup(conn, sid)
+	}
+}
+
+func cmdPlayers(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
+	GlobalConfig.RLock()
+	sc, ok := GlobalConfig.LaunchedHandlers[sid]
+	GlobalConfig.RUnlock()
+	if !ok {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not list players, invalid SID."})
+		return
+	}
+
+	players := sc.Players()
+	GlobalSockets.SendTo(conn, sc.playerMessage(&PlayerEvent{Event: "list"}))
+	if len(players) == 0 {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "No players online."})
+		return
+	}
+	for _, p := range players {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, fmt.Sprintf("%v (%v) online for %v", p.Name, p.IP, time.Since(p.Joined).Round(time.Second))})
+	}
+}
+
+func helpPlayer(conn *websocket.Conn, sid int) {
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":player history \"<name>\""})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":player seen \"<name>\""})
+}
+
+func cmdPlayer(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
+	if len(args) < 3 {
+		helpPlayer(conn, sid)
+		return
+	}
+	GlobalConfig.RLock()
+	sc, ok := GlobalConfig.LaunchedHandlers[sid]
+	GlobalConfig.RUnlock()
+	if !ok {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not look up player, invalid SID."})
+		return
+	}
+
+	var online *PlayerInfo
+	for _, p := range sc.Players() {
+		if strings.EqualFold(p.Name, args[2]) {
+			online = p
+			break
+		}
+	}
+
+	switch args[1] {
+	case "history":
+		sessions, err := PlayerHistory(sid, args[2])
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not read player history: " + err.Error()})
+			return
+		}
+		if len(sessions) == 0 && online == nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "No sessions recorded for " + args[2] + "."})
+			return
+		}
+		if len(sessions) > maxHistory {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, fmt.Sprintf("%v sessions recorded, showing the last %v.", len(sessions), maxHistory)})
+			sessions = sessions[len(sessions)-maxHistory:]
+		}
+		for _, ps := range sessions {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ps.String()})
+		}
+		if online != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, fmt.Sprintf("%v from %v: %v to now", online.Name, online.IP, online.Joined.Format("2006-01-02 15:04:05"))})
+		}
+	case "seen":
+		if online != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, fmt.Sprintf("%v is online now from %v, joined %v.", online.Name, online.IP, online.Joined.Format("2006-01-02 15:04:05"))})
+			return
+		}
+		sessions, err := PlayerHistory(sid, args[2])
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not read player history: " + err.Error()})
+			return
+		}
+		if len(sessions) == 0 {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, args[2] + " has never been seen on this server."})
+			return
+		}
+		last := sessions[len(sessions)-1]
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, fmt.Sprintf("%v was last seen %v from %v.", last.Name, last.End.Format("2006-01-02 15:04:05"), last.IP)})
+	default:
+		helpPlayer(conn, sid)
+	}
+}
+
+func helpLog(conn *websocket.Conn, sid int) {
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":log more [<count>]"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":log search \"<regex>\" [--since <time>] [--until <time>] [--class <class>]"})
+}
+
+func cmdLog(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
+	if len(args) < 2 {
+		helpLog(conn, sid)
+		return
+	}
+	if conn == nil {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Log commands must be run from a client."})
+		return
+	}
+	switch args[1] {
+	case "more":
+		n := defaultPageSize
+		if len(args) > 2 {
+			v, err := strconv.Atoi(args[2])
+			if err != nil || v <= 0 {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Invalid count."})
+				return
+			}
+			n = v
+			if n > maxPageSize {
+				n = maxPageSize
+			}
+		}
+
+		page, err := HistoryPage(sid, GlobalSockets.cursor(conn, sid), n)
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not read log archive: " + err.Error()})
+			return
+		}
+		if len(page) == 0 {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "No older messages."})
+			return
+		}
+		GlobalSockets.SendTo(conn, historyMessage(sid, page))
+	case "search":
+		if len(args) < 3 {
+			helpLog(conn, sid)
+			return
+		}
+		re, err := regexp.Compile(args[2])
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Invalid regex: " + err.Error()})
+			return
+		}
+		q := &logQuery{re: re}
+		for i := 3; i < len(args); i += 2 {
+			if i+1 >= len(args) {
+				helpLog(conn, sid)
+				return
+			}
+			ok := true
+			switch args[i] {
+			case "--since":
+				q.since, ok = parseSearchTime(args[i+1])
+			case "--until":
+				q.until, ok = parseSearchTime(args[i+1])
+			case "--class":
+				q.class = args[i+1]
+			default:
+				helpLog(conn, sid)
+				return
+			}
+			if !ok {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Invalid time: " + args[i+1]})
+				return
+			}
+		}
+
+		// Search every server the user can see.
+		GlobalConfig.RLock()
+		sids := []int{}
+		for s := range GlobalConfig.Servers {
+			if usr.IsAdmin || usr.Servers[s] {
+				sids = append(sids, s)
+			}
+		}
+		GlobalConfig.RUnlock()
+		sort.Ints(sids)
+
+		found := 0
+		for _, s := range sids {
+			err := SearchLogs(s, q, func(msg *LogMessage) bool {
+				GlobalSockets.SendTo(conn, searchMessage(sid, msg))
+				found++
+				return found < maxSearchResults
+			})
+			if err != nil {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, fmt.Sprintf("Could not search logs for SID %v: %v", s, err)})
+			}
+			if found >= maxSearchResults {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, fmt.Sprintf("Stopped after %v matches, try a narrower search.", maxSearchResults)})
+				return
+			}
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, fmt.Sprintf("Search done, %v matches.", found)})
+	default:
+		helpLog(conn, sid)
+	}
+}
+
+func helpSubscribe(conn *websocket.Conn, sid int) {
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":subscribe [(on|off) [<sid>|all]]"})
+}
+
+func cmdSubscribe(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
+	if conn == nil {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Subscriptions can only be changed from a client."})
+		return
+	}
+	if len(args) < 2 {
+		muted := GlobalSockets.Unsubscribed(conn)
+		if len(muted) == 0 {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Subscribed to all servers."})
+			return
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, fmt.Sprintf("Unsubscribed from SIDs: %v", muted)})
+		return
+	}
+
+	on := false
+	switch args[1] {
+	case "on":
+		on = true
+	case "off":
+	default:
+		helpSubscribe(conn, sid)
+		return
+	}
+
+	// Which servers? Default to the current one.
+	sids := []int{sid}
+	if len(args) > 2 {
+		GlobalConfig.RLock()
+		if args[2] == "all" {
+			sids = []int{0}
+			for s := range GlobalConfig.Servers {
+				sids = append(sids, s)
+			}
+		} else {
+			s, err := strconv.Atoi(args[2])
+			_, ok := GlobalConfig.Servers[s]
+			if err != nil || (!ok && s != 0) {
+				GlobalConfig.RUnlock()
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Invalid SID."})
+				return
+			}
+			sids = []int{s}
+		}
+		GlobalConfig.RUnlock()
+	}
+
+	for _, s := range sids {
+		GlobalSockets.Subscribe(conn, s, on)
+	}
+	if on {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Subscribed."})
+	} else {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Unsubscribed. Use :subscribe on to get messages again."})
+	}
+}
+
+func helpFilter(conn *websocket.Conn, sid int) {
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":filter [clear]"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":filter class [\"<class>\" ...]"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":filter match [\"<regex>\"]"})
+}
+
+func cmdFilter(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
+	if conn == nil {
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Filters can only be changed from a client."})
+		return
+	}
+	if len(args) < 2 {
+		f := GlobalSockets.UpdateFilter(conn, sid, func(f *logFilter) {})
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, f.String()})
+		return
+	}
+
+	var update func(f *logFilter)
+	switch args[1] {
+	case "clear":
+		update = func(f *logFilter) {
+			f.classes = map[string]bool{}
+			f.re = nil
+		}
+	case "class":
+		update = func(f *logFilter) {
+			f.classes = map[string]bool{}
+			for _, class := range args[2:] {
+				f.classes[strings.ToLower(class)] = true
+			}
+		}
+	case "match":
+		var re *regexp.Regexp
+		if len(args) > 2 {
+			var err error
+			re, err = regexp.Compile(args[2])
+			if err != nil {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Invalid regex: " + err.Error()})
+				return
+			}
+		}
+		update = func(f *logFilter) {
+			f.re = re
+		}
+	default:
+		helpFilter(conn, sid)
+		return
+	}
+
+	f := GlobalSockets.UpdateFilter(conn, sid, update)
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, f.String()})
+}
+
+func helpSchedule(conn *websocket.Conn, sid int) {
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":schedule add \"<cron>\" \"<command>\""})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":schedule list"})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":schedule remove <id>"})
+}
+
+func cmdSchedule(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
+	if len(args) < 2 {
+		helpSchedule(conn, sid)
+		return
+	}
+	switch args[1] {
+	case "add":
+		if len(args) < 4 {
+			helpSchedule(conn, sid)
+			return
+		}
+		job, err := GlobalConfig.AddJob(sid, args[2], args[3], usr.Name)
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, err.Error()})
+			return
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Job added: " + job.String()})
+		GlobalConfig.Dump()
+	case "list":
+		GlobalConfig.RLock()
+		lines := []string{}
+		for _, job := range GlobalConfig.Schedule {
+			if !usr.IsAdmin && !usr.Servers[job.SID] {
+				continue
+			}
+			lines = append(lines, job.String())
+		}
+		GlobalConfig.RUnlock()
+		if len(lines) == 0 {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "No scheduled jobs."})
+		}
+		for _, line := range lines {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, line})
+		}
+	case "remove":
+		if len(args) < 3 {
+			helpSchedule(conn, sid)
+			return
+		}
+		id, err := strconv.Atoi(args[2])
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not remove job, invalid ID."})
+			return
+		}
+		GlobalConfig.RLock()
+		allowed := false
+		for _, job := range GlobalConfig.Schedule {
+			if job.ID == id {
+				allowed = usr.IsAdmin || usr.Servers[job.SID]
+				break
+			}
+		}
+		GlobalConfig.RUnlock()
+		if !allowed || !GlobalConfig.RemoveJob(id) {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not remove job, no such job."})
+			return
+		}
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Job removed."})
+		GlobalConfig.Dump()
+	default:
+		helpSchedule(conn, sid)
+	}
+}
+
 func helpKill(conn *websocket.Conn, sid int) {
 	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":kill (monitor|server)"})
 }
@@ -186,7 +1054,9 @@ func cmdKill(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
 			return
 		}
 		// TODO: Require the user to run the command twice within a time limit to confirm.
-		GlobalConfig.Dump() // Just in case.
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Stopping all servers and exiting the monitor..."})
+		GlobalConfig.Shutdown()
+		GlobalConfig.Dump()
 		os.Exit(0)
 	case "server":
 		GlobalConfig.RLock()
@@ -196,6 +1066,7 @@ func cmdKill(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
 			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not kill server, invalid SID."})
 			return
 		}
+		sc.CancelRestart()
 		ok = sc.Kill()
 		if !ok {
 			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not kill server, server not up."})
@@ -207,7 +1078,8 @@ func cmdKill(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
 }
 
 func helpUser(conn *websocket.Conn, sid int) {
-	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":user (create|delete) \"<name>\""})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":user (create|delete|rotate) \"<name>\""})
+	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":user password \"<name>\" [\"<password>\"]"})
 	GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, ":user authorize \"<name>\" [<sid>|admin]"})
 }
 
@@ -236,37 +1108,116 @@ func cmdUser(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
 			return
 		}
 
-		b := make([]byte, 16)
-		_, err := rand.Read(b)
+		token, err := newToken()
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Error creating user token."})
+			return
+		}
+		hash, err := hashToken(token)
 		if err != nil {
 			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Error creating user token."})
 			return
 		}
-		token := fmt.Sprintf("%X", b)
 
 		GlobalConfig.Lock()
 		hastokens := len(GlobalConfig.Tokens) > 0
-		GlobalConfig.Tokens[token] = &MonitorUser{
+		GlobalConfig.Tokens[hash] = &MonitorUser{
 			Name:    args[2],
 			IsAdmin: !hastokens,
 			Servers: make(map[int]bool),
 		}
 		GlobalConfig.Unlock()
 		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "New user created! Token: " + token})
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Save this token now, it can't be shown again. Use :user rotate if it is lost."})
 		GlobalConfig.Dump()
+
+		// Anyone connected without an account has to log in now.
+		if !hastokens {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Enter your token in the settings to log in."})
+			GlobalSockets.Disconnect(rootUser)
+		}
 	case "delete":
-		GlobalConfig.RLock()
+		GlobalConfig.Lock()
+		var cusr *MonitorUser
 		for tkn, u := range GlobalConfig.Tokens {
 			if u.Name == args[2] {
+				cusr = u
 				delete(GlobalConfig.Tokens, tkn)
 				break
 			}
 		}
-		GlobalConfig.RUnlock()
+		GlobalConfig.Unlock()
+		if cusr != nil {
+			GlobalSessions.Revoke(cusr)
+			GlobalSockets.Disconnect(cusr)
+		}
 		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "User deleted."})
 		GlobalConfig.Dump()
+	case "rotate":
+		token, err := newToken()
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Error creating user token."})
+			return
+		}
+		hash, err := hashToken(token)
+		if err != nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Error creating user token."})
+			return
+		}
+
+		GlobalConfig.Lock()
+		key := GlobalConfig.tokenKey(args[2])
+		cusr := GlobalConfig.Tokens[key]
+		if cusr != nil {
+			delete(GlobalConfig.Tokens, key)
+			GlobalConfig.Tokens[hash] = cusr
+		}
+		GlobalConfig.Unlock()
+		if cusr == nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Could not rotate token, user not found."})
+			return
+		}
+
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Token replaced! New token: " + token})
+		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Save this token now, it can't be shown again."})
+		GlobalConfig.Dump()
+
+		// The old token is no longer valid, so neither are any sessions opened with it.
+		GlobalSockets.Disconnect(cusr)
+	case "password":
+		hash := ""
+		if len(args) > 3 && args[3] != "" {
+			var err error
+			hash, err = hashPassword(args[3])
+			if err != nil {
+				GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, err.Error()})
+				return
+			}
+		}
+
+		GlobalConfig.Lock()
+		cusr := GlobalConfig.Tokens[GlobalConfig.tokenKey(args[2])]
+		if cusr != nil {
+			cusr.Password = hash
+		}
+		GlobalConfig.Unlock()
+		if cusr == nil {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Could not set password, user not found."})
+			return
+		}
+
+		if hash == "" {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Password removed."})
+		} else {
+			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Password set."})
+		}
+		GlobalConfig.Dump()
+
+		// Anyone logged in with the old password has to log in again.
+		GlobalSessions.Revoke(cusr)
+		GlobalSockets.Disconnect(cusr)
 	case "authorize":
-		GlobalConfig.RLock()
+		GlobalConfig.Lock()
 		var cusr *MonitorUser
 		for _, u := range GlobalConfig.Tokens {
 			if u.Name == args[2] {
@@ -275,8 +1226,8 @@ func cmdUser(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
 			}
 		}
 		if cusr == nil {
+			GlobalConfig.Unlock()
 			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Could not authorize, user not found."})
-			GlobalConfig.RUnlock()
 			return
 		}
 		if len(args) >= 4 {
@@ -285,8 +1236,8 @@ func cmdUser(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
 			} else {
 				s, err := strconv.Atoi(args[3])
 				if err != nil {
+					GlobalConfig.Unlock()
 					GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Could not authorize, invalid SID."})
-					GlobalConfig.RUnlock()
 					return
 				}
 				cusr.Servers[s] = true
@@ -294,11 +1245,12 @@ func cmdUser(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
 		} else {
 			cusr.Servers[sid] = true
 		}
-		GlobalConfig.RUnlock()
+		GlobalConfig.Unlock()
+		GlobalSockets.Reauthorize(cusr)
 		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "User authorized."})
 		GlobalConfig.Dump()
 	case "deauthorize":
-		GlobalConfig.RLock()
+		GlobalConfig.Lock()
 		var cusr *MonitorUser
 		for _, u := range GlobalConfig.Tokens {
 			if u.Name == args[2] {
@@ -307,8 +1259,8 @@ func cmdUser(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
 			}
 		}
 		if cusr == nil {
+			GlobalConfig.Unlock()
 			GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Could not deauthorize, user not found."})
-			GlobalConfig.RUnlock()
 			return
 		}
 		if len(args) >= 4 {
@@ -317,8 +1269,8 @@ func cmdUser(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
 			} else {
 				s, err := strconv.Atoi(args[3])
 				if err != nil {
+					GlobalConfig.Unlock()
 					GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "Could not deauthorize, invalid SID."})
-					GlobalConfig.RUnlock()
 					return
 				}
 				delete(cusr.Servers, s)
@@ -326,7 +1278,8 @@ func cmdUser(conn *websocket.Conn, usr *MonitorUser, args []string, sid int) {
 		} else {
 			delete(cusr.Servers, sid)
 		}
-		GlobalConfig.RUnlock()
+		GlobalConfig.Unlock()
+		GlobalSockets.Reauthorize(cusr)
 		GlobalSockets.SendTo(conn, &LogMessage{sid, time.Now(), MonitorClass, "User deauthorized."})
 		GlobalConfig.Dump()
 	default:
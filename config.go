@@ -24,6 +24,7 @@ package main
 
 import "os"
 import "sync"
+import "time"
 import "errors"
 import "net/http"
 import "encoding/json"
@@ -39,21 +40,46 @@ var GlobalConfig *MonitorConfig
 type MonitorConfig struct {
 	ServerDir string
 	DataDir   string
+	BackupDir string // Backups for each server are stored in BackupDir/SID
 	LastSID   int
 
 	HostName string
 	Port     string // Not used if AutoTLS is set.
 	AutoTLS  bool
 
+	// How many seconds to wait for a server to exit after sending /stop before killing it.
+	StopTimeout int
+
+	// How many seconds to wait between starting each AutoStart server when the monitor launches.
+	AutoStartDelay int
+
+	// Default launch settings for all servers. See LaunchConfig for details.
+	Launch *LaunchConfig
+
+	// A cgroup v2 directory the monitor may create child cgroups in, used for server resource limits.
+	// The memory and cpu controllers must be enabled in its cgroup.subtree_control.
+	CgroupRoot string
+
+	// How server logs are archived. See LogArchiveConfig for details.
+	LogArchive *LogArchiveConfig
+
+	// How many recent messages to keep for each server and send to clients when they connect. 0 for the default,
+	// less than 0 to disable.
+	ReplayDepth int
+
 	// What servers are installed.
 	Servers map[int]*ServerConfig
 
 	// What versions of the game are currently installed and what is their status.
 	Versions map[string]BinaryStatus
 
-	// Tokens to users.
+	// Token hashes to users. See tokens.go.
 	Tokens map[string]*MonitorUser
 
+	// Scheduled jobs.
+	Schedule  []*ScheduledJob
+	LastJobID int
+
 	// Servers that currently have running monitors.
 	LaunchedHandlers map[int]*ServerController `json:"-"`
 
@@ -78,10 +104,60 @@ func (c *MonitorConfig) Dump() error {
 	return nil
 }
 
+// stopTimeout returns how long to wait for a server to stop before killing it.
+func (c *MonitorConfig) stopTimeout() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.StopTimeout <= 0 {
+		return defaultStopTimeout
+	}
+	return time.Duration(c.StopTimeout) * time.Second
+}
+
+// Shutdown stops every server and exits their controllers. Servers that take too long to stop are killed.
+func (c *MonitorConfig) Shutdown() {
+	c.RLock()
+	scs := make([]*ServerController, 0, len(c.LaunchedHandlers))
+	for _, sc := range c.LaunchedHandlers {
+		scs = append(scs, sc)
+	}
+	c.RUnlock()
+
+	// The controllers kill their servers after the stop timeout on their own, this extra time is just in
+	// case a controller is stuck.
+	deadline := time.After(c.stopTimeout() + 10*time.Second)
+
+	wg := new(sync.WaitGroup)
+	for _, sc := range scs {
+		wg.Add(1)
+		go func(sc *ServerController) {
+			sc.Exit()
+			wg.Done()
+		}(sc)
+	}
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-deadline:
+		for _, sc := range scs {
+			if sc.IsAlive() {
+				sc.forceKill()
+			}
+		}
+	}
+}
+
 type MonitorUser struct {
-	Name    string
-	IsAdmin bool
-	Servers map[int]bool
+	Name     string
+	IsAdmin  bool
+	Servers  map[int]bool
+	Password string `json:",omitempty"` // bcrypt hash, empty if the user can't log in with a password.
 }
 
 // BinaryStatus is the status of a set of server binaries.
@@ -100,6 +176,14 @@ type ServerConfig struct {
 	Version string // The current version used for this server.
 	Stable  bool   // Should this server track stable or unstable versions?
 
+	AutoStart bool // Should this server be started when the monitor launches?
+
+	Restart  *RestartPolicy  // What to do when the server crashes. If nil, DefaultRestartPolicy is used.
+	Watchdog *WatchdogConfig // Hang detection settings. If nil, DefaultWatchdog is used.
+	Launch   *LaunchConfig   // Launch settings, overriding the monitor defaults. May be nil.
+	Limits   *ResourceLimits // Resource limits applied when the server starts. May be nil.
+	Backups  *BackupSchedule // Scheduled backup settings. May be nil.
+
 	sync.RWMutex `json:"-"`
 }
 
@@ -0,0 +1,121 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "fmt"
+import "time"
+
+// restartWarnings are the points at which players are warned of an upcoming restart.
+var restartWarnings = []time.Duration{
+	10 * time.Minute,
+	5 * time.Minute,
+	1 * time.Minute,
+	30 * time.Second,
+	10 * time.Second,
+}
+
+// RestartIn restarts the server after the given delay, warning players in game as the restart gets closer.
+// Any countdown that is already running is replaced. Returns false if the server is not up.
+func (sc *ServerController) RestartIn(d time.Duration) bool {
+	if !sc.IsUp() {
+		return false
+	}
+
+	cancel := make(chan struct{})
+	sc.cmu.Lock()
+	if sc.countdown != nil {
+		close(sc.countdown)
+	}
+	sc.countdown = cancel
+	sc.cmu.Unlock()
+
+	go sc.restartCountdown(d, cancel)
+	return true
+}
+
+// CancelRestart cancels a pending countdown restart. Returns false if there is none.
+func (sc *ServerController) CancelRestart() bool {
+	sc.cmu.Lock()
+	defer sc.cmu.Unlock()
+
+	if sc.countdown == nil {
+		return false
+	}
+	close(sc.countdown)
+	sc.countdown = nil
+	return true
+}
+
+func (sc *ServerController) restartCountdown(d time.Duration, cancel chan struct{}) {
+	at := time.Now().Add(d)
+	sc.Command("/announce The server will restart in " + playerDuration(d) + ".")
+
+	for _, w := range restartWarnings {
+		if w >= d {
+			continue
+		}
+		select {
+		case <-cancel:
+			sc.Command("/announce The server restart has been cancelled.")
+			return
+		case <-time.After(time.Until(at.Add(-w))):
+			sc.Command("/announce The server will restart in " + playerDuration(w) + ".")
+		}
+	}
+
+	select {
+	case <-cancel:
+		sc.Command("/announce The server restart has been cancelled.")
+		return
+	case <-time.After(time.Until(at)):
+	}
+
+	sc.cmu.Lock()
+	if sc.countdown != cancel {
+		// Cancelled (or replaced) just as the countdown ran out.
+		sc.cmu.Unlock()
+		return
+	}
+	sc.countdown = nil
+	sc.cmu.Unlock()
+
+	if !sc.Stop(true) {
+		sc.emit(&LogMessage{sc.sid, time.Now(), ErrorClass, "Countdown restart not done, server not up."})
+	}
+}
+
+// playerDuration formats a duration in a way that reads well in an announcement.
+func playerDuration(d time.Duration) string {
+	unit, div := "second", time.Second
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		unit, div = "hour", time.Hour
+	case d >= time.Minute && d%time.Minute == 0:
+		unit, div = "minute", time.Minute
+	}
+	n := int64(d / div)
+	if n == 1 {
+		return fmt.Sprintf("1 %v", unit)
+	}
+	return fmt.Sprintf("%v %vs", n, unit)
+}
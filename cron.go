@@ -0,0 +1,202 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "fmt"
+import "time"
+import "errors"
+import "strconv"
+import "strings"
+
+var scheduleSyntaxError = errors.New("Invalid schedule, expected a cron expression like \"0 4 * * *\" or \"@every 6h\".")
+
+// Schedule is a parsed cron expression or fixed interval.
+//
+// Cron expressions have the usual five fields (minute, hour, day of month, month, day of week), each of
+// which may be "*", a number, a range ("1-5"), a step ("*/15" or "0-30/10"), or a comma separated list
+// of any of those. The shortcuts "@hourly", "@daily", "@midnight", and "@weekly" are also understood, as
+// is "@every <duration>" (for example "@every 90m") for a fixed interval.
+type Schedule struct {
+	Spec string
+
+	every time.Duration // If not zero, the schedule is a fixed interval and the rest is unused.
+
+	minute, hour, dom, month, dow uint64 // Bit sets, bit n is set if value n matches.
+	domAny, dowAny                bool
+}
+
+// ParseSchedule parses a cron expression or interval.
+func ParseSchedule(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	s := &Schedule{Spec: spec}
+
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	}
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil || d < time.Minute {
+			return nil, scheduleSyntaxError
+		}
+		s.every = d
+		return s, nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, scheduleSyntaxError
+	}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is also Sunday.
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return s, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			v, err := strconv.Atoi(part[i+1:])
+			if err != nil || v <= 0 {
+				return 0, scheduleSyntaxError
+			}
+			step = v
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			if i := strings.Index(part, "-"); i != -1 {
+				lo, err = strconv.Atoi(part[:i])
+				if err != nil {
+					return 0, scheduleSyntaxError
+				}
+				hi, err = strconv.Atoi(part[i+1:])
+				if err != nil {
+					return 0, scheduleSyntaxError
+				}
+			} else {
+				lo, err = strconv.Atoi(part)
+				if err != nil {
+					return 0, scheduleSyntaxError
+				}
+				hi = lo
+				if step != 1 {
+					hi = max // "5/15" means starting at 5, every 15.
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, scheduleSyntaxError
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	// Like cron, if both day fields are restricted either one matching is enough.
+	if !s.domAny && !s.dowAny {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// Next returns the first time after t that the schedule fires. Returns the zero time if the schedule can
+// never fire (for example, "0 0 31 2 *").
+//
+// Times skipped when the clocks go forward for daylight saving are skipped, and times repeated when they go
+// back only fire the first time.
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every != 0 {
+		return t.Add(s.every)
+	}
+
+	after := wallClock(t)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0, !wallClock(t).After(after):
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+
+		// A time that doesn't exist because of daylight saving (2:00 on the day the clocks go forward, say)
+		// may be normalized to one before t. Step forward a minute instead so the loop can't get stuck.
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// wallClock returns the time shown on the clock at t, to the minute, ignoring the time zone.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func (s *Schedule) String() string {
+	if s.every != 0 {
+		return fmt.Sprintf("every %v", s.every)
+	}
+	return s.Spec
+}
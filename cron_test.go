@@ -0,0 +1,194 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "time"
+import "testing"
+
+func TestParseCronField(t *testing.T) {
+	bits := func(vs ...int) uint64 {
+		var b uint64
+		for _, v := range vs {
+			b |= 1 << uint(v)
+		}
+		return b
+	}
+
+	tests := []struct {
+		field    string
+		min, max int
+		bits     uint64
+		ok       bool
+	}{
+		{"*", 0, 5, bits(0, 1, 2, 3, 4, 5), true},
+		{"3", 0, 59, bits(3), true},
+		{"1-4", 0, 59, bits(1, 2, 3, 4), true},
+		{"*/15", 0, 59, bits(0, 15, 30, 45), true},
+		{"0-30/10", 0, 59, bits(0, 10, 20, 30), true},
+		{"5/20", 0, 59, bits(5, 25, 45), true},
+		{"1,3,5-6", 0, 59, bits(1, 3, 5, 6), true},
+		{"1-31", 1, 31, bits(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31), true},
+		{"0", 1, 31, 0, false},
+		{"60", 0, 59, 0, false},
+		{"5-3", 0, 59, 0, false},
+		{"1-60", 0, 59, 0, false},
+		{"*/0", 0, 59, 0, false},
+		{"*/x", 0, 59, 0, false},
+		{"a", 0, 59, 0, false},
+		{"1-", 0, 59, 0, false},
+		{"", 0, 59, 0, false},
+		{"1,,2", 0, 59, 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseCronField(test.field, test.min, test.max)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v, expected ok %v", test.field, err, test.ok)
+			continue
+		}
+		if test.ok && got != test.bits {
+			t.Errorf("%q: got %b, expected %b", test.field, got, test.bits)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{"0 4 * * *", true},
+		{"*/5 * * * 1-5", true},
+		{"0 0 * * 7", true},
+		{"@hourly", true},
+		{"@daily", true},
+		{"@midnight", true},
+		{"@weekly", true},
+		{"@every 6h", true},
+		{"@every 90m", true},
+		{"@every 30s", false},
+		{"@every soon", false},
+		{"@yearly", false},
+		{"0 4 * *", false},
+		{"0 4 * * * *", false},
+		{"0 24 * * *", false},
+		{"0 0 0 * *", false},
+		{"0 0 * 13 *", false},
+		{"0 0 * * 8", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		_, err := ParseSchedule(test.spec)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v, expected ok %v", test.spec, err, test.ok)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	utc := func(y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		spec string
+		from time.Time
+		next time.Time
+	}{
+		{"0 4 * * *", utc(2026, 5, 10, 3, 59), utc(2026, 5, 10, 4, 0)},
+		{"0 4 * * *", utc(2026, 5, 10, 4, 0), utc(2026, 5, 11, 4, 0)},
+		{"*/15 * * * *", utc(2026, 5, 10, 4, 7), utc(2026, 5, 10, 4, 15)},
+		{"@hourly", utc(2026, 5, 10, 23, 30), utc(2026, 5, 11, 0, 0)},
+		{"@every 6h", utc(2026, 5, 10, 23, 30), utc(2026, 5, 11, 5, 30)},
+
+		// Month, year, and leap year boundaries.
+		{"0 0 1 * *", utc(2026, 1, 31, 12, 0), utc(2026, 2, 1, 0, 0)},
+		{"0 0 1 * *", utc(2026, 12, 15, 0, 0), utc(2027, 1, 1, 0, 0)},
+		{"0 0 31 * *", utc(2026, 1, 31, 0, 0), utc(2026, 3, 31, 0, 0)},
+		{"0 0 30 * *", utc(2026, 1, 30, 0, 0), utc(2026, 3, 30, 0, 0)},
+		{"0 0 29 2 *", utc(2026, 1, 1, 0, 0), utc(2028, 2, 29, 0, 0)},
+		{"59 23 * * *", utc(2026, 2, 28, 23, 59), utc(2026, 3, 1, 23, 59)},
+
+		// Day of week, and either day field matching when both are set.
+		{"0 0 * * 0", utc(2026, 5, 10, 0, 0), utc(2026, 5, 17, 0, 0)},
+		{"0 0 * * 7", utc(2026, 5, 10, 0, 0), utc(2026, 5, 17, 0, 0)},
+		{"0 9 * * 1-5", utc(2026, 5, 8, 9, 0), utc(2026, 5, 11, 9, 0)},
+		{"0 0 13 * 5", utc(2026, 5, 10, 0, 0), utc(2026, 5, 13, 0, 0)},
+		{"0 0 13 * 5", utc(2026, 5, 13, 0, 0), utc(2026, 5, 15, 0, 0)},
+
+		// Never fires.
+		{"0 0 31 2 *", utc(2026, 1, 1, 0, 0), time.Time{}},
+	}
+	for _, test := range tests {
+		s, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+		if got := s.Next(test.from); !got.Equal(test.next) {
+			t.Errorf("%q from %v: got %v, expected %v", test.spec, test.from, got, test.next)
+		}
+	}
+}
+
+func TestScheduleNextDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("No time zone data:", err)
+	}
+	local := func(y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, ny)
+	}
+	// 1:00 EST on the day the clocks go back is the second time 1:00 happens that day.
+	est := time.FixedZone("EST", -5*60*60)
+
+	// In 2026 the clocks go forward at 2:00 on March 8, and back at 2:00 on November 1.
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		next []time.Time
+	}{
+		{"skipped time is skipped", "30 2 * * *", local(2026, 3, 8, 0, 0), []time.Time{local(2026, 3, 9, 2, 30), local(2026, 3, 10, 2, 30)}},
+		{"midnight across spring forward", "0 0 * * *", local(2026, 3, 7, 12, 0), []time.Time{local(2026, 3, 8, 0, 0), local(2026, 3, 9, 0, 0)}},
+		{"hourly across spring forward", "0 * * * *", local(2026, 3, 8, 0, 30), []time.Time{local(2026, 3, 8, 1, 0), local(2026, 3, 8, 3, 0), local(2026, 3, 8, 4, 0)}},
+		{"repeated time fires once", "30 1 * * *", local(2026, 11, 1, 0, 0), []time.Time{local(2026, 11, 1, 1, 30), local(2026, 11, 2, 1, 30)}},
+		{"after running in the first pass", "45 1 * * *", local(2026, 11, 1, 1, 45), []time.Time{local(2026, 11, 2, 1, 45)}},
+		{"from the second pass", "45 1 * * *", time.Date(2026, 11, 1, 1, 10, 0, 0, est), []time.Time{time.Date(2026, 11, 1, 1, 45, 0, 0, est)}},
+		{"hourly across fall back", "0 * * * *", local(2026, 11, 1, 0, 30), []time.Time{local(2026, 11, 1, 1, 0), local(2026, 11, 1, 2, 0), local(2026, 11, 1, 3, 0)}},
+	}
+	for _, test := range tests {
+		s, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		at := test.from
+		for i, expected := range test.next {
+			at = s.Next(at)
+			if !at.Equal(expected) {
+				t.Errorf("%v: run %v got %v, expected %v", test.name, i+1, at, expected)
+				break
+			}
+		}
+	}
+}
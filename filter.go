@@ -0,0 +1,131 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "sort"
+import "regexp"
+import "strings"
+
+import "github.com/gorilla/websocket"
+
+// logFilter limits which messages from a server are sent to a client. An empty filter allows everything.
+type logFilter struct {
+	classes map[string]bool // Lower case. If not empty, only these classes are sent.
+	re      *regexp.Regexp  // If not nil, only messages matching this are sent.
+}
+
+func (f *logFilter) allows(msg *LogMessage) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.classes) > 0 && !f.classes[strings.ToLower(msg.Class)] {
+		return false
+	}
+	return f.re == nil || f.re.MatchString(msg.Message)
+}
+
+func (f *logFilter) String() string {
+	if f == nil || (len(f.classes) == 0 && f.re == nil) {
+		return "No filter, showing everything."
+	}
+	out := "Showing"
+	if len(f.classes) > 0 {
+		classes := []string{}
+		for class := range f.classes {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		out += " classes: " + strings.Join(classes, ", ")
+	} else {
+		out += " all classes"
+	}
+	if f.re != nil {
+		out += ", matching: " + f.re.String()
+	}
+	return out + "."
+}
+
+// wants returns true if the client should be sent a broadcast message. The caller must hold the Sockets lock.
+func (c *socketClient) wants(msg *LogMessage) bool {
+	switch msg.Class {
+	case InitClass, RemoveClass:
+		return true // Clients need these to keep track of servers.
+	}
+	if c.muted[msg.SID] {
+		return false
+	}
+	return c.filters[msg.SID].allows(msg)
+}
+
+// Subscribe turns sending broadcasts from a server to a client on or off.
+func (s *Sockets) Subscribe(conn *websocket.Conn, sid int, on bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	client, ok := s.clients[conn]
+	if !ok {
+		return
+	}
+	if on {
+		delete(client.muted, sid)
+	} else {
+		client.muted[sid] = true
+	}
+}
+
+// Unsubscribed returns the servers a client has turned off.
+func (s *Sockets) Unsubscribed(conn *websocket.Conn) []int {
+	s.Lock()
+	defer s.Unlock()
+
+	sids := []int{}
+	if client, ok := s.clients[conn]; ok {
+		for sid := range client.muted {
+			sids = append(sids, sid)
+		}
+	}
+	sort.Ints(sids)
+	return sids
+}
+
+// UpdateFilter changes a client's filter for a server, and returns the new filter.
+func (s *Sockets) UpdateFilter(conn *websocket.Conn, sid int, update func(f *logFilter)) *logFilter {
+	s.Lock()
+	defer s.Unlock()
+
+	client, ok := s.clients[conn]
+	if !ok {
+		return nil
+	}
+	f, ok := client.filters[sid]
+	if !ok {
+		f = &logFilter{classes: map[string]bool{}}
+	}
+	update(f)
+	if len(f.classes) == 0 && f.re == nil {
+		delete(client.filters, sid)
+		return nil
+	}
+	client.filters[sid] = f
+	return f
+}
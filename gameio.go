@@ -23,9 +23,12 @@ misrepresented as being the original software.
 package main
 
 import "io"
+import "os"
 import "time"
 import "bufio"
+import "syscall"
 import "regexp"
+import "strings"
 
 func (sc *ServerController) logTransmitter() {
 	for {
@@ -55,7 +58,7 @@ func (sc *ServerController) commandStuffer() {
 				if wrt != nil {
 					_, _ = wrt.Write([]byte(cmd + "\n"))
 				} else {
-					sc.logs <- &LogMessage{sc.sid, time.Now(), ErrorClass, "Cannot send command, server is not running."}
+					sc.emit(&LogMessage{sc.sid, time.Now(), ErrorClass, "Cannot send command, server is not running."})
 				}
 			}
 		}
@@ -66,6 +69,12 @@ const (
 	MonitorClass = "Monitor"
 	ErrorClass   = "Monitor Error"
 	InitClass    = "Monitor Init"
+	RemoveClass  = "Monitor Remove"
+	StateClass   = "Monitor State"
+	PlayerClass  = "Monitor Players"
+	HistoryClass = "Monitor History"
+	SearchClass  = "Monitor Search"
+	StderrClass  = "Stderr"
 )
 
 type LogMessage struct {
@@ -75,6 +84,27 @@ type LogMessage struct {
 	Message string
 }
 
+// ServerState is sent JSON encoded as the message of a StateClass log message when a server starts or stops.
+type ServerState struct {
+	State    string // "running", "stopped", "killed", "hung", or "crashed"
+	PID      int
+	ExitCode int    // -1 if the server is running or was killed by a signal.
+	Signal   string // The signal that killed the server, if any.
+}
+
+func exitState(state string, ps *os.ProcessState) *ServerState {
+	st := &ServerState{State: state, ExitCode: -1}
+	if ps == nil {
+		return st
+	}
+	st.PID = ps.Pid()
+	st.ExitCode = ps.ExitCode()
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		st.Signal = ws.Signal().String()
+	}
+	return st
+}
+
 //var loglineRe = regexp.MustCompile(`([0-9]+\.[0-9]+\.[0-9]+ [0-9]+:[0-9]+:[0-9]+) \[([a-zA-Z ]+)\] (.*)\n`)
 var loglineRe = regexp.MustCompile(`([0-9]+:[0-9]+:[0-9]+) \[([a-zA-Z ]+)\] (.*)\n`)
 
@@ -96,10 +126,11 @@ func (sc *ServerController) logParser() {
 
 		for {
 			line, err := brdr.ReadString('\n')
+			sc.touch()
 			if err == io.EOF {
 				break
 			} else if err != nil {
-				sc.logs <- &LogMessage{sc.sid, time.Now(), ErrorClass, err.Error()}
+				sc.emit(&LogMessage{sc.sid, time.Now(), ErrorClass, err.Error()})
 				break // I'll just assume all IO errors mean that the pipe is borked in some way.
 			}
 
@@ -107,7 +138,7 @@ func (sc *ServerController) logParser() {
 			// For now we will use the lazy way and split it up with a regex.
 			matches := loglineRe.FindStringSubmatch(line)
 			if matches == nil {
-				sc.logs <- &LogMessage{sc.sid, last, lastClass, line}
+				sc.emit(&LogMessage{sc.sid, last, lastClass, line})
 				continue
 			}
 
@@ -117,12 +148,112 @@ func (sc *ServerController) logParser() {
 			//t, err := time.Parse("2.1.2006 15:04:05", matches[1])
 			t, err := time.ParseInLocation("15:04:05", matches[1], time.Local)
 			if err != nil {
-				sc.logs <- &LogMessage{sc.sid, time.Now(), ErrorClass, err.Error()}
+				sc.emit(&LogMessage{sc.sid, time.Now(), ErrorClass, err.Error()})
 				t = time.Now()
+			} else {
+				t = logClock(t)
 			}
 			last = t
 			lastClass = matches[2]
-			sc.logs <- &LogMessage{sc.sid, t, matches[2], matches[3]}
+			msg := &LogMessage{sc.sid, t, matches[2], matches[3]}
+			sc.notify(msg)
+			sc.emit(msg)
+			sc.trackPlayers(msg)
+		}
+		sc.readers.Done()
+	}
+}
+
+// logClock turns a time of day from the server log into a full timestamp. The game only logs the time, so the
+// date is assumed to be today, or yesterday if that would put the message in the future.
+func logClock(clock time.Time) time.Time {
+	now := time.Now()
+	t := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, time.Local)
+	if t.After(now.Add(time.Minute)) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// errParser forwards anything the server writes to stderr. Nothing the game normally logs goes here,
+// so there is no attempt to parse it.
+func (sc *ServerController) errParser() {
+	var rdr io.ReadCloser
+	ok := false
+	for {
+		rdr, ok = <-sc.e
+		if !ok {
+			return
+		}
+		if rdr == nil {
+			continue
+		}
+
+		brdr := bufio.NewReader(rdr)
+		for {
+			line, err := brdr.ReadString('\n')
+			if len(line) > 0 {
+				sc.touch()
+				sc.emit(&LogMessage{sc.sid, time.Now(), StderrClass, strings.TrimRight(line, "\r\n")})
+			}
+			if err != nil {
+				break
+			}
+		}
+		sc.readers.Done()
+	}
+}
+
+type logWaiter struct {
+	re *regexp.Regexp
+	ok chan bool
+}
+
+// expect starts watching for the server to log a message matching re. Call this before sending the command that
+// causes the message, otherwise a fast reply could be missed. The waiter must be removed with unexpect.
+func (sc *ServerController) expect(re *regexp.Regexp) *logWaiter {
+	w := &logWaiter{re, make(chan bool, 1)}
+	sc.wmu.Lock()
+	sc.waiters = append(sc.waiters, w)
+	sc.wmu.Unlock()
+	return w
+}
+
+// unexpect removes a waiter added by expect.
+func (sc *ServerController) unexpect(w *logWaiter) {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	for i, v := range sc.waiters {
+		if v == w {
+			sc.waiters = append(sc.waiters[:i], sc.waiters[i+1:]...)
+			break
+		}
+	}
+}
+
+// wait blocks until the server logs a matching message (at any time since the waiter was added) or the timeout
+// expires. Returns false on timeout.
+func (w *logWaiter) wait(timeout time.Duration) bool {
+	select {
+	case <-w.ok:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// notify wakes any waiters that match a message from the server.
+func (sc *ServerController) notify(msg *LogMessage) {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	for _, w := range sc.waiters {
+		if w.re.MatchString(msg.Message) {
+			select {
+			case w.ok <- true:
+			default:
+			}
 		}
 	}
 }
@@ -23,15 +23,25 @@ misrepresented as being the original software.
 package main
 
 import "io"
+import "os"
+import "encoding/json"
 import "fmt"
 import "time"
-import "runtime"
-import "os/exec"
+import "context"
+import "sync"
 import "sync/atomic"
 
 const (
-	countRestarts = 3
-	loopThreshold = 60 * time.Second
+	defaultStopTimeout = 60 * time.Second
+)
+
+// stopMode tells the controller loop what to do once a server has been stopped.
+type stopMode int
+
+const (
+	stopDown    stopMode = iota // Leave the server down and wait for :recover.
+	stopRestart                 // Start the server back up.
+	stopExit                    // Exit the controller loop.
 )
 
 type ServerController struct {
@@ -42,11 +52,40 @@ type ServerController struct {
 	cmds    chan string
 	restart chan bool
 	kill    chan bool
+	stop    chan stopMode
+	hung    chan time.Duration
 	i       chan io.WriteCloser
 	o       chan io.ReadCloser
+	e       chan io.ReadCloser
+	exited  chan struct{} // Closed once the controller has exited and all logs have been sent.
+	archive *logArchive   // Every log message is saved here before it is sent to clients.
+
+	lmu    sync.Mutex
+	closed bool // Set once logs is closed, anything logging after that is dropped.
+
+	readers sync.WaitGroup // The server's output pipes still being read. cmd.Wait must wait for these to finish.
+
+	ready     chan struct{} // Closed once the controller loop is running and can take orders.
+	readyOnce sync.Once
 
 	isup    *int32 // Is the server running?
 	isalive *int32 // Is the monitor loop running?
+	lastout *int64 // When did the server last write anything? (UnixNano)
+
+	pmu  sync.Mutex
+	proc *os.Process // The running server process, if any.
+
+	dmu sync.Mutex // Held while a backup is being restored, the server is not started or adopted until it is released.
+
+	wmu     sync.Mutex
+	waiters []*logWaiter // Things waiting for the server to log a specific message.
+
+	cmu       sync.Mutex
+	countdown chan struct{} // Closed to cancel a pending countdown restart, nil if there is none.
+
+	plmu    sync.Mutex
+	players map[string]*PlayerInfo // Online players by name.
+	uids    map[string]string      // Player UIDs logged before the player finished joining, by name.
 }
 
 // NewServerController creates a new server control instance.
@@ -58,21 +97,34 @@ func (c *MonitorConfig) NewServerController(sid int) *ServerController {
 		cmds:    make(chan string),
 		restart: make(chan bool),
 		kill:    make(chan bool),
+		stop:    make(chan stopMode),
+		hung:    make(chan time.Duration),
 		i:       make(chan io.WriteCloser),
 		o:       make(chan io.ReadCloser),
+		e:       make(chan io.ReadCloser),
+		exited:  make(chan struct{}),
+		ready:   make(chan struct{}),
+		archive: c.newLogArchive(sid),
+		players: map[string]*PlayerInfo{},
+		uids:    map[string]string{},
 		isup:    new(int32),
 		isalive: new(int32),
+		lastout: new(int64),
 	}
 
 	go sc.restartLoop()
 	go sc.logParser()
+	go sc.errParser()
 	go sc.commandStuffer()
 	go func() {
+		defer close(sc.exited)
 		for {
 			log, ok := <-sc.logs
 			if !ok {
+				sc.archive.Close()
 				return
 			}
+			sc.archive.Write(log)
 			GlobalSockets.Broadcast(log)
 		}
 	}()
@@ -89,6 +141,62 @@ func (sc *ServerController) Kill() bool {
 	return false
 }
 
+// Stop orders the server to save and shutdown when up, restarting it afterwards if restart is true.
+// If the server does not exit on its own within the configured timeout it is killed. Returns false
+// if the server is not up.
+func (sc *ServerController) Stop(restart bool) bool {
+	if atomic.LoadInt32(sc.isup) != 0 && atomic.LoadInt32(sc.isalive) != 0 {
+		if restart {
+			sc.stop <- stopRestart
+		} else {
+			sc.stop <- stopDown
+		}
+		return true
+	}
+	return false
+}
+
+// Exit orders the server to save and shutdown if it is up, then orders the controller loop to exit.
+// Blocks until the controller has exited.
+func (sc *ServerController) Exit() {
+	if atomic.LoadInt32(sc.isalive) == 0 {
+		return
+	}
+	if atomic.LoadInt32(sc.isup) != 0 {
+		sc.stop <- stopExit
+	} else {
+		sc.restart <- false
+	}
+	<-sc.exited
+}
+
+// forceKill kills the server process without going through the controller loop. This is only for
+// when the controller is not responding.
+func (sc *ServerController) forceKill() error {
+	sc.pmu.Lock()
+	defer sc.pmu.Unlock()
+
+	if sc.proc == nil {
+		return nil
+	}
+	return sc.proc.Kill()
+}
+
+// markReady reports that the controller loop is running.
+func (sc *ServerController) markReady() {
+	sc.readyOnce.Do(func() { close(sc.ready) })
+}
+
+// WaitReady waits for the controller loop to start. Returns false if it did not start in time.
+func (sc *ServerController) WaitReady(timeout time.Duration) bool {
+	select {
+	case <-sc.ready:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 // Start orders the server to start when down. Returns false if the server is not down.
 func (sc *ServerController) Start() bool {
 	if atomic.LoadInt32(sc.isup) == 0 && atomic.LoadInt32(sc.isalive) != 0 {
@@ -98,6 +206,17 @@ func (sc *ServerController) Start() bool {
 	return false
 }
 
+// Halt orders the controller loop to exit when the server is down, and waits for it to finish. Returns
+// false if the server is not down.
+func (sc *ServerController) Halt() bool {
+	if atomic.LoadInt32(sc.isup) == 0 && atomic.LoadInt32(sc.isalive) != 0 {
+		sc.restart <- false
+		<-sc.exited
+		return true
+	}
+	return false
+}
+
 // IsUp returns true if the server is running.
 func (sc *ServerController) IsUp() bool {
 	return atomic.LoadInt32(sc.isup) != 0
@@ -121,48 +240,186 @@ func (sc *ServerController) Command(cmd string) bool {
 
 // controller
 func (sc *ServerController) log(f string, v ...interface{}) {
-	sc.logs <- &LogMessage{sc.sid, time.Now(), MonitorClass, fmt.Sprintf(f, v...)}
+	sc.emit(&LogMessage{sc.sid, time.Now(), MonitorClass, fmt.Sprintf(f, v...)})
+}
+
+// emit queues a log message for the server. This is safe to call from outside the controller, if the
+// controller has already exited the message is dropped and false is returned. Nothing may send on logs
+// directly, the output readers for a server that could not be killed may still be running after exit.
+func (sc *ServerController) emit(msg *LogMessage) bool {
+	sc.lmu.Lock()
+	defer sc.lmu.Unlock()
+	if sc.closed {
+		return false
+	}
+	sc.logs <- msg
+	return true
+}
+
+// state sends a server state message. If the server is no longer running the online player list is cleared.
+func (sc *ServerController) state(st *ServerState) {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return
+	}
+	sc.emit(&LogMessage{sc.sid, time.Now(), StateClass, string(b)})
+
+	if st.State != "running" {
+		sc.clearPlayers()
+	}
+}
+
+// exit marks the controller as dead and shuts down the IO goroutines.
+func (sc *ServerController) exit() {
+	atomic.StoreInt32(sc.isalive, 0)
+	close(sc.i)
+	close(sc.o)
+	close(sc.e)
+
+	sc.lmu.Lock()
+	sc.closed = true
+	close(sc.logs)
+	sc.lmu.Unlock()
+}
+
+// adopt watches a server process left running by an earlier monitor until it exits or is killed. The
+// process has no console attached, so commands cannot be sent to it. Returns false if the controller
+// should exit.
+func (sc *ServerController) adopt(pf *PidFile) bool {
+	sc.dmu.Lock()
+	atomic.StoreInt32(sc.isup, -1) // Not really up, but this keeps :recover from starting a second copy.
+	sc.dmu.Unlock()
+	sc.markReady()
+	sc.i <- nil
+	sc.o <- nil
+	sc.e <- nil
+	defer atomic.StoreInt32(sc.isup, 0)
+
+	sc.log("Found server process %v left running by an earlier monitor (started %v).", pf.PID, pf.Started.Format(time.RFC1123))
+	sc.log("The server console is not available, use :kill server if you want to restart it.")
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !pf.Alive() {
+				removePidFile(pf.DataPath)
+				sc.log("Server process %v has exited.", pf.PID)
+				return true
+			}
+		case keepgoing := <-sc.kill:
+			p, err := os.FindProcess(pf.PID)
+			if err == nil {
+				err = p.Kill()
+			}
+			if err != nil {
+				sc.log("Failed to kill server: %v", err)
+				continue
+			}
+			removePidFile(pf.DataPath)
+			sc.log("Server killed.")
+			return keepgoing
+		case mode := <-sc.stop:
+			if mode == stopExit {
+				sc.log("Leaving server process %v running, controller is exiting.", pf.PID)
+				return false
+			}
+			sc.log("Cannot stop server without a console, use :kill server instead.")
+		}
+	}
 }
 
 func (sc *ServerController) restartLoop() {
 	atomic.StoreInt32(sc.isalive, -1)
 
-	var restarts [countRestarts]time.Time
+	// Make sure there isn't already a copy of this server running.
+	sc.c.RLock()
+	if sd, ok := sc.c.Servers[sc.sid]; ok {
+		sd.RLock()
+		dat := fmt.Sprintf("%v/%v %v", sc.c.DataDir, sd.Name, sd.SID)
+		sd.RUnlock()
+		sc.c.RUnlock()
+
+		pf, err := readPidFile(dat)
+		if err == nil && pf.Alive() {
+			if !sc.adopt(pf) {
+				sc.exit()
+				return
+			}
+		} else if err == nil {
+			removePidFile(dat)
+		}
+	} else {
+		sc.c.RUnlock()
+	}
+	sc.markReady()
+
+	var crashes []time.Time
+	var delay time.Duration
 	autostart := false
 
+	// crashed applies the restart policy after the server dies unexpectedly.
+	crashed := func(sd *ServerConfig) {
+		var ok bool
+		policy := sd.Policy()
+		crashes, delay, ok = policy.Backoff(crashes, time.Now())
+		if !ok {
+			if policy.Never {
+				sc.log("Restart policy forbids automatic restarts.")
+			} else {
+				sc.log("Server crashed more than %v times in %vs, giving up.", policy.MaxRestarts, policy.Window)
+			}
+			sc.log("Server is DOWN, awaiting :recover command.")
+			autostart = false
+			return
+		}
+		autostart = true
+	}
+
 	for {
 		if !autostart {
 			atomic.StoreInt32(sc.isup, 0) // Alert the main system that the server is down and needs user intervention.
 			sc.i <- nil                   // Stop IO.
 			sc.o <- nil
+			sc.e <- nil
 			autostart = true
-			<-sc.restart // Wait for the main system to reply with a :recover command.
-		}
 
-		if t := time.Since(restarts[0]); t < loopThreshold {
-			sc.log("%d automatic server restarts in %v.", countRestarts, t)
-			sc.log("Server is DOWN, awaiting :recover command.")
-			autostart = false
-			continue
+			// Wait for the main system to reply with a :recover command (or to tell us to exit).
+			if !<-sc.restart {
+				sc.log("Controller is exiting.")
+				sc.exit()
+				return
+			}
+		} else if delay > 0 {
+			atomic.StoreInt32(sc.isup, 0) // The server is down, but we will restart it on our own.
+			sc.i <- nil
+			sc.o <- nil
+			sc.e <- nil
+
+			sc.log("Restarting server in %v, use :recover to restart it now.", delay)
+			select {
+			case <-time.After(delay):
+			case ok := <-sc.restart:
+				if !ok {
+					sc.log("Controller is exiting.")
+					sc.exit()
+					return
+				}
+			}
 		}
-
-		copy(restarts[0:], restarts[1:])
-		restarts[len(restarts)-1] = time.Now()
+		delay = 0
 
 		sc.log("(re)starting server...")
 
 		// Grab the paths needed:
 		sc.c.RLock()
 		sd, ok := sc.c.Servers[sc.sid]
-		bin := sc.c.ServerDir
 		dat := sc.c.DataDir
 		sc.c.RUnlock()
 		if !ok {
 			sc.log("Fatal error, invalid SID (should be impossible).")
-			atomic.StoreInt32(sc.isalive, 0)
-			close(sc.i)
-			close(sc.o)
-			close(sc.logs)
+			sc.exit()
 			return
 		}
 		sd.RLock()
@@ -181,17 +438,17 @@ func (sc *ServerController) restartLoop() {
 			continue
 		}
 
-		bin += fmt.Sprintf("/%v/VintagestoryServer.exe", ver)
 		dat += fmt.Sprintf("/%v %v", name, sid)
 
 		// And run the server!
-		// HACK! But I'm feeling lazy, have pity on me.
-		var cmd *exec.Cmd
-		if runtime.GOOS == "windows" {
-			cmd = exec.Command(bin, "--dataPath", dat)
-		} else {
-			cmd = exec.Command("mono", bin, "--dataPath", dat)
+		launch, err := sc.c.LaunchSettings(sid)
+		if err != nil {
+			sc.log("Could not restart server (reading launch settings): %v", err)
+			sc.log("Server is DOWN, awaiting :recover command.")
+			autostart = false
+			continue
 		}
+		cmd := launch.Command(context.Background())
 
 		ipipe, err := cmd.StdinPipe()
 		if err != nil {
@@ -209,23 +466,73 @@ func (sc *ServerController) restartLoop() {
 			continue
 		}
 
+		epipe, err := cmd.StderrPipe()
+		if err != nil {
+			sc.log("Could not restart server (attaching error pipe): %v", err)
+			sc.log("Server is DOWN, awaiting :recover command.")
+			autostart = false
+			continue
+		}
+
+		// Limits are set up before the server starts, a server that should be limited never runs without them.
+		cgroup := sc.c.cgroupDir(sid)
+		started, err := prepareLimits(cmd, sd.LimitSettings(), cgroup)
+		if err != nil {
+			sc.log("Could not restart server (applying resource limits): %v", err)
+			sc.log("Server is DOWN, awaiting :recover command.")
+			autostart = false
+			continue
+		}
+
+		sc.dmu.Lock()
 		err = cmd.Start()
+		started()
 		if err != nil {
+			sc.dmu.Unlock()
+			releaseLimits(cgroup)
 			sc.log("Could not restart server: %v", err)
 			sc.log("Server is DOWN, awaiting :recover command.")
 			autostart = false
 			continue
 		}
 		atomic.StoreInt32(sc.isup, -1) // Alert the system that the server is up.
+		sc.dmu.Unlock()
+		sc.pmu.Lock()
+		sc.proc = cmd.Process
+		sc.pmu.Unlock()
+		err = writePidFile(dat, cmd.Process.Pid)
+		if err != nil {
+			sc.log("Could not write PID file: %v", err)
+		}
+
+		sc.state(&ServerState{State: "running", PID: cmd.Process.Pid})
+		sc.touch()
 
+		sc.readers.Add(2)
 		sc.i <- ipipe
 		sc.o <- opipe
+		sc.e <- epipe
 
 		done := make(chan error, 1)
+		quit := make(chan bool)
 		go func() {
-			done <- cmd.Wait()
+			// cmd.Wait closes the pipes, so wait for the parsers to read everything first or the last few
+			// lines (usually the interesting ones) may be lost.
+			sc.readers.Wait()
+			err := cmd.Wait()
+			close(quit)
+			removePidFile(dat)
+			releaseLimits(cgroup)
+			sc.pmu.Lock()
+			sc.proc = nil
+			sc.pmu.Unlock()
+			done <- err
 		}()
 
+		if wd := sd.WatchdogConfig(); wd.Enabled {
+			go sc.watchdog(wd, quit)
+		}
+
 		select {
 		case keepgoing := <-sc.kill:
 			if err := cmd.Process.Kill(); err != nil {
@@ -234,28 +541,96 @@ func (sc *ServerController) restartLoop() {
 				autostart = false
 				continue
 			}
+			<-done
 			sc.log("Server killed.")
+			sc.state(exitState("killed", cmd.ProcessState))
 			if !keepgoing {
 				sc.log("Server is DOWN, and controller is exiting.")
-				atomic.StoreInt32(sc.isalive, 0)
-				close(sc.i)
-				close(sc.o)
-				close(sc.logs)
+				sc.exit()
 				return
 			}
 			sc.log("Server is DOWN, awaiting :recover command.")
 			autostart = false
 			continue
+		case mode := <-sc.stop:
+			timeout := sc.c.stopTimeout()
+			sc.log("Stopping server (will be killed if still running in %v)...", timeout)
+
+			// Sending /stop can block if the server isn't reading its input, so that counts against the timeout
+			// as well.
+			deadline := time.After(timeout)
+			stopcmd := sc.cmds // Set to nil once /stop has been sent.
+			forcekill := false
+			for waiting := true; waiting; {
+				select {
+				case stopcmd <- "/stop":
+					stopcmd = nil
+				case err := <-done:
+					if err != nil {
+						sc.log("Server exited with error: %v", err)
+					}
+					waiting = false
+				case keepgoing := <-sc.kill:
+					forcekill = true
+					if !keepgoing {
+						mode = stopExit
+					}
+					waiting = false
+				case <-deadline:
+					sc.log("Server did not stop in time.")
+					forcekill = true
+					waiting = false
+				}
+			}
+			if forcekill {
+				if err := cmd.Process.Kill(); err != nil {
+					sc.log("Failed to kill server: %v", err)
+					sc.log("Server is ROGUE, run for your lives!")
+					autostart = false
+					continue
+				}
+				<-done
+				sc.log("Server killed.")
+				sc.state(exitState("killed", cmd.ProcessState))
+			} else {
+				sc.log("Server stopped.")
+				sc.state(exitState("stopped", cmd.ProcessState))
+			}
+
+			if mode == stopRestart {
+				autostart = true
+				continue
+			}
+			if mode == stopExit {
+				sc.log("Server is DOWN, and controller is exiting.")
+				sc.exit()
+				return
+			}
+			sc.log("Server is DOWN, awaiting :recover command.")
+			autostart = false
+			continue
+		case silent := <-sc.hung:
+			sc.log("Server has not responded for %v, it appears to be hung.", silent)
+			if err := cmd.Process.Kill(); err != nil {
+				sc.log("Failed to kill server: %v", err)
+				sc.log("Server is ROGUE, run for your lives!")
+				autostart = false
+				continue
+			}
+			<-done
+			sc.state(exitState("hung", cmd.ProcessState))
+			crashed(sd)
 		case err := <-done:
 			if err == nil {
 				sc.log("Server exited intentionally.")
+				sc.state(exitState("stopped", cmd.ProcessState))
 				sc.log("Server is DOWN, awaiting :recover command.")
 				autostart = false
 				continue
 			}
 			sc.log("Server died: %v", err)
-			sc.log("Server is DOWN, awaiting :recover command.")
-			autostart = true
+			sc.state(exitState("crashed", cmd.ProcessState))
+			crashed(sd)
 		}
 	}
 }
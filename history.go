@@ -0,0 +1,136 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "os"
+import "fmt"
+import "sync"
+import "time"
+import "bufio"
+import "strings"
+import "encoding/json"
+
+// maxHistory is the most sessions :player history will show.
+const maxHistory = 20
+
+// PlayerSession is a single visit by a player to a server.
+type PlayerSession struct {
+	Name   string
+	UID    string // Empty if the server did not log it.
+	IP     string
+	Start  time.Time
+	End    time.Time
+	Reason string // Why the session ended, if known.
+}
+
+func (ps *PlayerSession) String() string {
+	uid := ""
+	if ps.UID != "" {
+		uid = ", UID " + ps.UID
+	}
+	reason := ""
+	if ps.Reason != "" {
+		reason = " (" + ps.Reason + ")"
+	}
+	return fmt.Sprintf("%v from %v%v: %v to %v%v", ps.Name, ps.IP, uid, ps.Start.Format("2006-01-02 15:04:05"), ps.End.Format("2006-01-02 15:04:05"), reason)
+}
+
+// historyLock keeps session writes from interleaving. Readers don't need it.
+var historyLock sync.Mutex
+
+// historyFile returns the path of the player session history for a server.
+func historyFile(sid int) string {
+	return fmt.Sprintf("%v/Monitor/players/%v.json", baseDir(), sid)
+}
+
+// RecordSession adds a finished player session to a server's history. Each session is stored as one line of JSON,
+// so the file never has to be rewritten.
+func RecordSession(sid int, ps *PlayerSession) error {
+	b, err := json.Marshal(ps)
+	if err != nil {
+		return err
+	}
+
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	err = os.MkdirAll(baseDir()+"/Monitor/players", 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyFile(sid), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// PlayerHistory returns every recorded session for the named player on a server, oldest first. Names are not case
+// sensitive. Sessions that are still in progress are not included.
+//
+// This does not take historyLock, so a slow scan never holds up recording sessions. Sessions are appended one
+// line at a time, so the worst that can happen is a partly written last line, which is skipped.
+func PlayerHistory(sid int, name string) ([]*PlayerSession, error) {
+	f, err := os.Open(historyFile(sid))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sessions := []*PlayerSession{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		ps := &PlayerSession{}
+		err := json.Unmarshal(scanner.Bytes(), ps)
+		if err != nil {
+			continue // A partly written line from a crash, most likely.
+		}
+		if strings.EqualFold(ps.Name, name) {
+			sessions = append(sessions, ps)
+		}
+	}
+	return sessions, scanner.Err()
+}
+
+// endSession records the session of a player who has left.
+func (sc *ServerController) endSession(p *PlayerInfo, at time.Time, reason string) {
+	err := RecordSession(sc.sid, &PlayerSession{
+		Name:   p.Name,
+		UID:    p.UID,
+		IP:     p.IP,
+		Start:  p.Joined,
+		End:    at,
+		Reason: reason,
+	})
+	if err != nil {
+		sc.emit(&LogMessage{sc.sid, time.Now(), ErrorClass, "Could not record player session: " + err.Error()})
+	}
+}
@@ -29,9 +29,8 @@ import "time"
 import "bytes"
 import "errors"
 import "context"
-import "runtime"
-import "os/exec"
 import "net/http"
+import "path/filepath"
 import "io/ioutil"
 import "crypto/md5"
 import "encoding/hex"
@@ -49,6 +48,7 @@ var versionFetchError = errors.New("Error parsing retrieved version information.
 var invalidSIDError = errors.New("Invalid or non-existent SID.")
 var versionValidError = errors.New("Version validation failed.")
 var md5ValidError = errors.New("MD5 validation failed.")
+var serverUpError = errors.New("Server is running, stop it first.")
 
 func GetLatestGameVersion(stable bool) (string, error) {
 	url := vStableURL
@@ -96,8 +96,6 @@ func (c *MonitorConfig) installNewServer(ver string, stable bool, name string) (
 		Stable:  stable,
 	}
 	os.Mkdir(fmt.Sprintf("%v/%v %v", c.DataDir, name, sid), 0755) // Ignore error.
-	bin := c.ServerDir
-	dat := c.DataDir
 	c.Unlock()
 
 	err = c.FindOrDownload(ver)
@@ -105,19 +103,15 @@ func (c *MonitorConfig) installNewServer(ver string, stable bool, name string) (
 		return sid, err
 	}
 
-	bin += fmt.Sprintf("/%v/VintagestoryServer.exe", ver)
-	dat += fmt.Sprintf("/%v %v", name, sid)
+	launch, err := c.LaunchSettings(sid)
+	if err != nil {
+		return sid, err
+	}
 
-	// HACK! But I'm feeling lazy, have pity on me.
 	// This has a deadline to keep old versions that do not support --genconfig from hanging forever.
-	var cmd *exec.Cmd
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if runtime.GOOS == "windows" {
-		cmd = exec.CommandContext(ctx, bin, "--dataPath", dat, "--genconfig")
-	} else {
-		cmd = exec.CommandContext(ctx, "mono", bin, "--dataPath", dat, "--genconfig")
-	}
+	cmd := launch.Command(ctx, "--genconfig")
 
 	err = cmd.Run()
 	if ctx.Err() == context.DeadlineExceeded {
@@ -159,6 +153,56 @@ func (c *MonitorConfig) UpdateServerTo(ver string, sid int) error {
 	return c.FindOrDownload(ver)
 }
 
+// DeleteServer shuts down the controller for a halted server and removes it from the config, along with any
+// scheduled jobs for it. The server's data directory is moved to DataDir/Deleted if archive is true, otherwise
+// it is removed along with the server's backups, log archive, and player history. SIDs are never reused, so
+// archived servers can keep those where they are.
+func (c *MonitorConfig) DeleteServer(sid int, archive bool) error {
+	c.RLock()
+	sd, ok := c.Servers[sid]
+	sc, launched := c.LaunchedHandlers[sid]
+	c.RUnlock()
+	if !ok {
+		return invalidSIDError
+	}
+
+	if launched {
+		if sc.IsAlive() && !sc.Halt() {
+			return serverUpError
+		}
+		sc.CancelRestart()
+	}
+
+	c.Lock()
+	delete(c.Servers, sid)
+	delete(c.LaunchedHandlers, sid)
+	for _, u := range c.Tokens {
+		delete(u.Servers, sid)
+	}
+	c.removeServerJobs(sid)
+	dat := c.DataDir
+	c.Unlock()
+
+	sd.RLock()
+	dir := fmt.Sprintf("%v/%v %v", dat, sd.Name, sid)
+	sd.RUnlock()
+
+	if !archive {
+		for _, extra := range []string{c.backupDir(sid), archiveDir(sid), historyFile(sid)} {
+			err := os.RemoveAll(extra)
+			if err != nil {
+				return err
+			}
+		}
+		return os.RemoveAll(dir)
+	}
+	err := os.MkdirAll(dat+"/Deleted", 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(dir, dat+"/Deleted/"+filepath.Base(dir))
+}
+
 func (c *MonitorConfig) FindOrDownload(ver string) error {
 	ok, stable, file, srmd5 := ValidateVersion(ver)
 	if !ok {
@@ -0,0 +1,147 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "os"
+import "fmt"
+import "context"
+import "os/exec"
+import "runtime"
+import "strconv"
+import "strings"
+
+// LaunchConfig describes how to run a server. The executable, arguments, and working directory may contain
+// the following variables:
+//
+//	$BINARY  Path to VintagestoryServer.exe for the server's version.
+//	$BINDIR  Directory holding the binaries for the server's version.
+//	$DATA    The server's data directory.
+//	$VERSION The server's game version.
+//	$NAME    The server's name.
+//	$SID     The server's SID.
+//
+// Anything else is looked up in the monitor's environment.
+type LaunchConfig struct {
+	Executable string   // If empty, the default is used.
+	Args       []string // If nil, the default is used.
+	Env        []string // Extra environment variables in KEY=value form. Added to the defaults.
+	WorkDir    string   // If empty, the default is used.
+}
+
+// defaultLaunch is used if neither the monitor or the server config say otherwise.
+func defaultLaunch() LaunchConfig {
+	if runtime.GOOS == "windows" {
+		return LaunchConfig{Executable: "$BINARY", Args: []string{"--dataPath", "$DATA"}}
+	}
+	return LaunchConfig{Executable: "mono", Args: []string{"$BINARY", "--dataPath", "$DATA"}}
+}
+
+// overlay returns a copy of l with any settings from o replacing the ones in l.
+func (l LaunchConfig) overlay(o *LaunchConfig) LaunchConfig {
+	if o == nil {
+		return l
+	}
+	if o.Executable != "" {
+		l.Executable = o.Executable
+	}
+	if o.Args != nil {
+		l.Args = o.Args
+	}
+	l.Env = append(append([]string{}, l.Env...), o.Env...)
+	if o.WorkDir != "" {
+		l.WorkDir = o.WorkDir
+	}
+	return l
+}
+
+// LaunchSettings returns the launch settings for a server with all variables expanded.
+func (c *MonitorConfig) LaunchSettings(sid int) (LaunchConfig, error) {
+	c.RLock()
+	sd, ok := c.Servers[sid]
+	if !ok {
+		c.RUnlock()
+		return LaunchConfig{}, invalidSIDError
+	}
+	l := defaultLaunch().overlay(c.Launch)
+	bin := c.ServerDir
+	dat := c.DataDir
+	c.RUnlock()
+
+	sd.RLock()
+	l = l.overlay(sd.Launch)
+	ver := sd.Version
+	name := sd.Name
+	sd.RUnlock()
+
+	vars := map[string]string{
+		"BINARY":  fmt.Sprintf("%v/%v/VintagestoryServer.exe", bin, ver),
+		"BINDIR":  fmt.Sprintf("%v/%v", bin, ver),
+		"DATA":    fmt.Sprintf("%v/%v %v", dat, name, sid),
+		"VERSION": ver,
+		"NAME":    name,
+		"SID":     strconv.Itoa(sid),
+	}
+	expand := func(s string) string {
+		return os.Expand(s, func(k string) string {
+			if v, ok := vars[k]; ok {
+				return v
+			}
+			return os.Getenv(k)
+		})
+	}
+
+	out := LaunchConfig{
+		Executable: expand(l.Executable),
+		WorkDir:    expand(l.WorkDir),
+	}
+	for _, arg := range l.Args {
+		out.Args = append(out.Args, expand(arg))
+	}
+	for _, env := range l.Env {
+		out.Env = append(out.Env, expand(env))
+	}
+	return out, nil
+}
+
+// Command creates a command from expanded launch settings, with any extra arguments added to the end.
+func (l LaunchConfig) Command(ctx context.Context, extra ...string) *exec.Cmd {
+	args := append(append([]string{}, l.Args...), extra...)
+	cmd := exec.CommandContext(ctx, l.Executable, args...)
+	if len(l.Env) > 0 {
+		cmd.Env = append(os.Environ(), l.Env...)
+	}
+	cmd.Dir = l.WorkDir
+	return cmd
+}
+
+// String returns the command line in a form suitable for showing to a user.
+func (l LaunchConfig) String() string {
+	parts := []string{}
+	for _, v := range append([]string{l.Executable}, l.Args...) {
+		if v == "" || strings.ContainsAny(v, " \t\"") {
+			v = strconv.Quote(v)
+		}
+		parts = append(parts, v)
+	}
+	return strings.Join(parts, " ")
+}
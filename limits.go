@@ -0,0 +1,86 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "fmt"
+import "strings"
+
+// ResourceLimits restricts the resources a server may use. Zero values mean no limit. The cgroup limits
+// require the monitor's CgroupRoot to be set to a cgroup v2 directory the monitor is allowed to manage.
+type ResourceLimits struct {
+	Nice         int // Process priority, from -20 (highest) to 19 (lowest).
+	AddressSpace int // Maximum virtual memory (RLIMIT_AS) in MB.
+	OpenFiles    int // Maximum number of open files (RLIMIT_NOFILE).
+	MemoryMax    int // cgroup memory.max in MB.
+	CPUMax       int // cgroup cpu.max, as a percentage of one CPU (200 = two full CPUs).
+}
+
+func (l ResourceLimits) String() string {
+	parts := []string{}
+	if l.Nice != 0 {
+		parts = append(parts, fmt.Sprintf("nice %v", l.Nice))
+	}
+	if l.AddressSpace > 0 {
+		parts = append(parts, fmt.Sprintf("address space %vMB", l.AddressSpace))
+	}
+	if l.OpenFiles > 0 {
+		parts = append(parts, fmt.Sprintf("%v open files", l.OpenFiles))
+	}
+	if l.MemoryMax > 0 {
+		parts = append(parts, fmt.Sprintf("cgroup memory %vMB", l.MemoryMax))
+	}
+	if l.CPUMax > 0 {
+		parts = append(parts, fmt.Sprintf("cgroup CPU %v%%", l.CPUMax))
+	}
+	if len(parts) == 0 {
+		return "No resource limits."
+	}
+	return "Resource limits: " + strings.Join(parts, ", ") + "."
+}
+
+// needsCgroup returns true if any of the limits require a cgroup.
+func (l ResourceLimits) needsCgroup() bool {
+	return l.MemoryMax > 0 || l.CPUMax > 0
+}
+
+// LimitSettings returns the resource limits for this server.
+func (sd *ServerConfig) LimitSettings() ResourceLimits {
+	sd.RLock()
+	defer sd.RUnlock()
+
+	if sd.Limits == nil {
+		return ResourceLimits{}
+	}
+	return *sd.Limits
+}
+
+// cgroupDir returns the cgroup directory used for a server, or "" if cgroups are not configured.
+func (c *MonitorConfig) cgroupDir(sid int) string {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.CgroupRoot == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v/server-%v", c.CgroupRoot, sid)
+}
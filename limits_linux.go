@@ -0,0 +1,160 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "os"
+import "fmt"
+import "errors"
+import "os/exec"
+import "runtime"
+import "strconv"
+import "strings"
+import "syscall"
+import "io/ioutil"
+
+import "golang.org/x/sys/unix"
+
+var noCgroupError = errors.New("Server has cgroup limits, but the monitor has no CgroupRoot set.")
+
+// limitsHelperArg is passed as the first argument when the monitor runs itself as the limits helper.
+const limitsHelperArg = "-apply-limits"
+
+// prepareLimits sets up a server command so its resource limits are in place before the server runs any
+// code. The process is started directly in its cgroup, and if there are rlimits or a nice value the command
+// is wrapped in the limits helper, which sets them and then execs the server. cgroup is the cgroup directory
+// to use for the server, or "" if cgroups are not available. The returned function must be called once the
+// command has been started (or failed to start).
+func prepareLimits(cmd *exec.Cmd, l ResourceLimits, cgroup string) (func(), error) {
+	done := func() {}
+	if l.needsCgroup() {
+		if cgroup == "" {
+			return nil, noCgroupError
+		}
+		err := os.MkdirAll(cgroup, 0755)
+		if err != nil {
+			return nil, err
+		}
+		if l.MemoryMax > 0 {
+			err = writeCgroup(cgroup, "memory.max", strconv.Itoa(l.MemoryMax*1024*1024))
+			if err != nil {
+				releaseLimits(cgroup)
+				return nil, err
+			}
+		}
+		if l.CPUMax > 0 {
+			// The quota is per 100ms period, so 100% of one CPU is 100000.
+			err = writeCgroup(cgroup, "cpu.max", strconv.Itoa(l.CPUMax*1000)+" 100000")
+			if err != nil {
+				releaseLimits(cgroup)
+				return nil, err
+			}
+		}
+		dir, err := os.Open(cgroup)
+		if err != nil {
+			releaseLimits(cgroup)
+			return nil, err
+		}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+		done = func() { dir.Close() }
+	}
+
+	if l.Nice != 0 || l.AddressSpace > 0 || l.OpenFiles > 0 {
+		self, err := os.Executable()
+		if err != nil {
+			done()
+			return nil, err
+		}
+		spec := fmt.Sprintf("%v,%v,%v", l.Nice, l.AddressSpace, l.OpenFiles)
+		cmd.Args = append([]string{self, limitsHelperArg, spec, cmd.Path}, cmd.Args[1:]...)
+		cmd.Path = self
+	}
+	return done, nil
+}
+
+// limitsHelper is run in place of the server when it has rlimits or a nice value. It applies them to itself
+// and then execs the server, so the limits are in place before the server starts. If anything fails the
+// server is not run at all. Never returns.
+func limitsHelper(args []string) {
+	err := execWithLimits(args)
+	fmt.Fprintf(os.Stderr, "Could not apply resource limits: %v\n", err)
+	os.Exit(127)
+}
+
+func execWithLimits(args []string) error {
+	if len(args) < 2 {
+		return errors.New("Missing arguments.")
+	}
+	spec := strings.Split(args[0], ",")
+	if len(spec) != 3 {
+		return errors.New("Invalid limits.")
+	}
+	vals := make([]int, len(spec))
+	for i, v := range spec {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		vals[i] = n
+	}
+	l := ResourceLimits{Nice: vals[0], AddressSpace: vals[1], OpenFiles: vals[2]}
+
+	if l.AddressSpace > 0 {
+		lim := uint64(l.AddressSpace) * 1024 * 1024
+		err := unix.Setrlimit(unix.RLIMIT_AS, &unix.Rlimit{Cur: lim, Max: lim})
+		if err != nil {
+			return err
+		}
+	}
+	if l.OpenFiles > 0 {
+		lim := uint64(l.OpenFiles)
+		err := unix.Setrlimit(unix.RLIMIT_NOFILE, &unix.Rlimit{Cur: lim, Max: lim})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Linux priorities are per thread, so the thread that sets the priority has to be the one that execs.
+	runtime.LockOSThread()
+	if l.Nice != 0 {
+		err := unix.Setpriority(unix.PRIO_PROCESS, 0, l.Nice)
+		if err != nil {
+			return err
+		}
+	}
+	return syscall.Exec(args[1], args[1:], os.Environ())
+}
+
+// releaseLimits cleans up after prepareLimits once the server process has exited.
+func releaseLimits(cgroup string) {
+	if cgroup != "" {
+		os.Remove(cgroup) // Ignore error, this fails if the cgroup was never created.
+	}
+}
+
+func writeCgroup(cgroup, file, value string) error {
+	return ioutil.WriteFile(cgroup+"/"+file, []byte(value), 0644)
+}
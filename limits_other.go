@@ -0,0 +1,54 @@
+//go:build !linux
+
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "os"
+import "fmt"
+import "errors"
+import "os/exec"
+
+var limitsUnsupportedError = errors.New("Resource limits are only supported on Linux.")
+
+// limitsHelperArg is passed as the first argument when the monitor runs itself as the limits helper. This
+// never happens on other platforms, but main checks for it anyway.
+const limitsHelperArg = "-apply-limits"
+
+// prepareLimits sets up a server command so its resource limits are in place before the server runs. There
+// is no support for limits here, so any limits at all are an error.
+func prepareLimits(cmd *exec.Cmd, l ResourceLimits, cgroup string) (func(), error) {
+	if l != (ResourceLimits{}) {
+		return nil, limitsUnsupportedError
+	}
+	return func() {}, nil
+}
+
+// limitsHelper is only used on Linux. Never returns.
+func limitsHelper(args []string) {
+	fmt.Fprintf(os.Stderr, "Could not apply resource limits: %v\n", limitsUnsupportedError)
+	os.Exit(127)
+}
+
+// releaseLimits cleans up after prepareLimits once the server process has exited.
+func releaseLimits(cgroup string) {}
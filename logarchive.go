@@ -0,0 +1,357 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "io"
+import "os"
+import "fmt"
+import "sync"
+import "sort"
+import "time"
+import "bufio"
+import "strings"
+import "io/ioutil"
+import "encoding/json"
+import "compress/gzip"
+
+const (
+	archiveCurrent = "current.json"        // The segment being written to.
+	archiveFormat  = "2006-01-02_15-04-05" // Old segments are named for the time of their first message.
+)
+
+// LogArchiveConfig controls how server logs are saved to disk. Each server's log is saved to Monitor/logs/SID as
+// JSON encoded LogMessages, one per line. When the current segment grows past MaxSize megabytes, or when the day
+// changes if Daily is set, it is gzipped and a new segment started. Old segments are deleted after KeepDays days.
+type LogArchiveConfig struct {
+	Disabled bool
+	MaxSize  int // Megabytes. 0 for no limit.
+	Daily    bool
+	KeepDays int // 0 to keep logs forever.
+}
+
+// DefaultLogArchive holds the settings used if the config file does not have any.
+var DefaultLogArchive = LogArchiveConfig{
+	Disabled: false,
+	MaxSize:  10,
+	Daily:    true,
+	KeepDays: 30,
+}
+
+// LogArchiveSettings returns the log archive settings.
+func (c *MonitorConfig) LogArchiveSettings() LogArchiveConfig {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.LogArchive == nil {
+		return DefaultLogArchive
+	}
+	return *c.LogArchive
+}
+
+// archiveDir returns the directory a server's logs are archived in.
+func archiveDir(sid int) string {
+	return fmt.Sprintf("%v/Monitor/logs/%v", baseDir(), sid)
+}
+
+type logArchive struct {
+	sync.Mutex
+
+	c   *MonitorConfig
+	dir string
+
+	f     *os.File
+	size  int64
+	start time.Time // When the first message in the current segment was logged.
+}
+
+func (c *MonitorConfig) newLogArchive(sid int) *logArchive {
+	return &logArchive{c: c, dir: archiveDir(sid)}
+}
+
+// Write saves a message to the archive. Errors are printed to the monitor's console, there isn't anywhere better
+// to send them.
+func (a *logArchive) Write(msg *LogMessage) {
+	a.Lock()
+	defer a.Unlock()
+
+	cfg := a.c.LogArchiveSettings()
+	if cfg.Disabled {
+		return
+	}
+
+	err := a.write(msg, cfg)
+	if err != nil {
+		fmt.Println("Error archiving log:", err)
+	}
+}
+
+func (a *logArchive) write(msg *LogMessage, cfg LogArchiveConfig) error {
+	if a.f == nil {
+		err := a.open(msg.At)
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.size > 0 && ((cfg.MaxSize > 0 && a.size >= int64(cfg.MaxSize)<<20) || (cfg.Daily && !sameDay(a.start, msg.At))) {
+		err := a.rotate(cfg)
+		if err != nil {
+			return err
+		}
+		err = a.open(msg.At)
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if a.size == 0 {
+		a.start = msg.At
+	}
+	n, err := a.f.Write(append(b, '\n'))
+	a.size += int64(n)
+	return err
+}
+
+// open opens the current segment, picking up where the last run of the monitor left off if it exists.
+func (a *logArchive) open(at time.Time) error {
+	err := os.MkdirAll(a.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(a.dir+"/"+archiveCurrent, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f = f
+	a.size = info.Size()
+	a.start = at
+	if a.size == 0 {
+		return nil
+	}
+
+	// Find out when the existing segment was started.
+	first, err := bufio.NewReader(io.NewSectionReader(f, 0, a.size)).ReadBytes('\n')
+	msg := &LogMessage{}
+	if err == nil && json.Unmarshal(first, msg) == nil {
+		a.start = msg.At
+	}
+	return nil
+}
+
+// rotate closes the current segment, compresses it, and removes any segments that are too old.
+func (a *logArchive) rotate(cfg LogArchiveConfig) error {
+	err := a.f.Close()
+	a.f = nil
+	if err != nil {
+		return err
+	}
+
+	name := unusedSegment(a.dir+"/"+a.start.Format(archiveFormat)) + ".json"
+	err = os.Rename(a.dir+"/"+archiveCurrent, name)
+	if err != nil {
+		return err
+	}
+	err = compressSegment(name)
+	if err != nil {
+		return err
+	}
+	return pruneArchive(a.dir, cfg.KeepDays)
+}
+
+// Close closes the current segment. The archive will reopen it if anything else is written.
+func (a *logArchive) Close() {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.f != nil {
+		a.f.Close()
+		a.f = nil
+	}
+}
+
+// unusedSegment returns a segment name (without extension) based on base that is not used by any existing
+// segment. Names only have one second resolution, so if base is taken a numeric suffix is added.
+func unusedSegment(base string) string {
+	name := base
+	for i := 1; segmentExists(name+".json") || segmentExists(name+".json.gz"); i++ {
+		name = fmt.Sprintf("%v_%02d", base, i)
+	}
+	return name
+}
+
+func segmentExists(name string) bool {
+	_, err := os.Lstat(name)
+	return !os.IsNotExist(err)
+}
+
+// compressSegment gzips an archive segment, replacing the original. If there is already a compressed segment
+// with the same name (say from a crash part way through compressing) the new one gets a different name.
+func compressSegment(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(name + ".gz.tmp")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		os.Remove(name + ".gz.tmp")
+		return err
+	}
+
+	target := name + ".gz"
+	if segmentExists(target) {
+		target = unusedSegment(strings.TrimSuffix(name, ".json")) + ".json.gz"
+	}
+	err = os.Rename(name+".gz.tmp", target)
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// pruneArchive deletes segments more than keep days old, and compresses any segments left uncompressed by a crash.
+func pruneArchive(dir string, keep int) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		switch {
+		case name == archiveCurrent:
+		case strings.HasSuffix(name, ".json"):
+			err := compressSegment(dir + "/" + name)
+			if err != nil {
+				return err
+			}
+		case strings.HasSuffix(name, ".json.gz"):
+			if keep > 0 && time.Since(info.ModTime()) > time.Duration(keep)*24*time.Hour {
+				err := os.Remove(dir + "/" + name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// archiveSegments returns the paths of all the archive segments for a server, oldest first. The current segment
+// is always last.
+func archiveSegments(sid int) ([]string, error) {
+	dir := archiveDir(sid)
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	segments := []string{}
+	current := false
+	for _, info := range infos {
+		switch {
+		case info.Name() == archiveCurrent:
+			current = true
+		case strings.HasSuffix(info.Name(), ".json.gz"):
+			segments = append(segments, dir+"/"+info.Name())
+		}
+	}
+	sort.Strings(segments)
+	if current {
+		segments = append(segments, dir+"/"+archiveCurrent)
+	}
+	return segments, nil
+}
+
+// segmentStart returns the time of the first message in a compressed segment, as recorded in its name. Any
+// suffix added by unusedSegment is ignored.
+func segmentStart(name string) (time.Time, bool) {
+	base := strings.TrimSuffix(name[strings.LastIndex(name, "/")+1:], ".json.gz")
+	if len(base) > len(archiveFormat) {
+		base = base[:len(archiveFormat)]
+	}
+	t, err := time.ParseInLocation(archiveFormat, base, time.Local)
+	return t, err == nil
+}
+
+// readSegment calls fn for each message in an archive segment, in order.
+func readSegment(name string, fn func(msg *LogMessage)) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		msg := &LogMessage{}
+		if json.Unmarshal(scanner.Bytes(), msg) != nil {
+			continue // Most likely a line that is still being written.
+		}
+		fn(msg)
+	}
+	return scanner.Err()
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
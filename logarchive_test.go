@@ -0,0 +1,162 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "os"
+import "strings"
+import "testing"
+import "time"
+import "path/filepath"
+
+func TestLogArchiveRotate(t *testing.T) {
+	old := baseDirV
+	defer func() { baseDirV = old }()
+
+	day1 := time.Date(2026, 5, 1, 10, 0, 0, 0, time.Local)
+	day2 := time.Date(2026, 5, 2, 10, 0, 0, 0, time.Local)
+	seg1 := day1.Format(archiveFormat)
+	seg2 := day2.Format(archiveFormat)
+	big := 600 * 1024 // Two of these fit in a 1MB segment, three don't.
+
+	type write struct {
+		at   time.Time
+		size int
+	}
+	tests := []struct {
+		name     string
+		cfg      LogArchiveConfig
+		writes   []write
+		segments []string // Segment names, oldest first. The current segment is always last.
+		counts   []int    // How many messages are in each segment.
+	}{
+		{"disabled", LogArchiveConfig{Disabled: true}, []write{{day1, 10}, {day2, 10}}, nil, nil},
+		{"no rotation", LogArchiveConfig{}, []write{{day1, 10}, {day2, 10}, {day2, big}}, []string{archiveCurrent}, []int{3}},
+		{"daily", LogArchiveConfig{Daily: true},
+			[]write{{day1, 10}, {day1.Add(time.Hour), 10}, {day2, 10}},
+			[]string{seg1 + ".json.gz", archiveCurrent}, []int{2, 1}},
+		{"size", LogArchiveConfig{MaxSize: 1},
+			[]write{{day1, big}, {day1, big}, {day1, big}},
+			[]string{seg1 + ".json.gz", archiveCurrent}, []int{2, 1}},
+		{"size, same second twice", LogArchiveConfig{MaxSize: 1},
+			[]write{{day1, big}, {day1, big}, {day1, big}, {day1, big}, {day1, big}},
+			[]string{seg1 + ".json.gz", seg1 + "_01.json.gz", archiveCurrent}, []int{2, 2, 1}},
+		{"daily, same start twice", LogArchiveConfig{Daily: true},
+			[]write{{day1, 10}, {day2, 10}, {day1, 10}, {day2, 10}},
+			[]string{seg1 + ".json.gz", seg1 + "_01.json.gz", seg2 + ".json.gz", archiveCurrent}, []int{1, 1, 1, 1}},
+	}
+	for i, test := range tests {
+		baseDirV = t.TempDir()
+		sid := i + 1
+		cfg := test.cfg
+		a := (&MonitorConfig{LogArchive: &cfg}).newLogArchive(sid)
+		for _, w := range test.writes {
+			a.Write(&LogMessage{sid, w.at, MonitorClass, strings.Repeat("x", w.size)})
+		}
+		a.Close()
+
+		segments, err := archiveSegments(sid)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if len(segments) != len(test.segments) {
+			t.Errorf("%v: got segments %q, expected %q", test.name, segments, test.segments)
+			continue
+		}
+		for j, seg := range segments {
+			if filepath.Base(seg) != test.segments[j] {
+				t.Errorf("%v: segment %v is %v, expected %v", test.name, j, filepath.Base(seg), test.segments[j])
+				continue
+			}
+			count := 0
+			err := readSegment(seg, func(msg *LogMessage) { count++ })
+			if err != nil {
+				t.Errorf("%v: reading %v: %v", test.name, test.segments[j], err)
+			}
+			if count != test.counts[j] {
+				t.Errorf("%v: segment %v has %v messages, expected %v", test.name, test.segments[j], count, test.counts[j])
+			}
+		}
+	}
+}
+
+func TestSegmentStart(t *testing.T) {
+	at := time.Date(2026, 5, 1, 10, 20, 30, 0, time.Local)
+	tests := []struct {
+		name string
+		at   time.Time
+		ok   bool
+	}{
+		{"/logs/1/" + at.Format(archiveFormat) + ".json.gz", at, true},
+		{"/logs/1/" + at.Format(archiveFormat) + "_01.json.gz", at, true},
+		{"/logs/1/" + at.Format(archiveFormat) + "_12.json.gz", at, true},
+		{"/logs/1/" + archiveCurrent, time.Time{}, false},
+		{"/logs/1/junk.json.gz", time.Time{}, false},
+	}
+	for _, test := range tests {
+		start, ok := segmentStart(test.name)
+		if ok != test.ok || !start.Equal(test.at) {
+			t.Errorf("%v: got (%v, %v), expected (%v, %v)", test.name, start, ok, test.at, test.ok)
+		}
+	}
+}
+
+func TestCompressSegmentNoOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	name := dir + "/" + time.Date(2026, 5, 1, 10, 0, 0, 0, time.Local).Format(archiveFormat) + ".json"
+
+	// A compressed segment with the same name already exists, as if a crash happened part way through
+	// compressing the first time.
+	err := os.WriteFile(name, []byte("{}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = compressSegment(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := os.ReadFile(name + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(name, []byte("{}\n{}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = compressSegment(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now, err := os.ReadFile(name + ".gz")
+	if err != nil || string(now) != string(old) {
+		t.Errorf("existing segment was changed (err: %v)", err)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(name, ".json") + "_01.json.gz"); err != nil {
+		t.Errorf("new segment not written under a new name: %v", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("uncompressed segment not removed: %v", err)
+	}
+}
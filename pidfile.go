@@ -0,0 +1,87 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "os"
+import "time"
+import "encoding/json"
+
+const pidFileName = "/VSMonitor.pid"
+
+// pidStartSlack is how far a process's start time may be from the time in its PID file. The file is written just
+// after the process starts, start times read from the system are not exact, and the clock may have been adjusted
+// since.
+const pidStartSlack = time.Minute
+
+// PidFile records a launched server process in the server's data directory, so that if the monitor
+// dies a new monitor can find the server instead of starting a second copy on the same world.
+type PidFile struct {
+	PID      int
+	Started  time.Time
+	DataPath string // The data path the server was launched with, used to make sure a PID wasn't reused.
+}
+
+func writePidFile(dat string, pid int) error {
+	f, err := os.Create(dat + pidFileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(&PidFile{
+		PID:      pid,
+		Started:  time.Now(),
+		DataPath: dat,
+	})
+}
+
+func readPidFile(dat string) (*PidFile, error) {
+	f, err := os.Open(dat + pidFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pf := new(PidFile)
+	err = json.NewDecoder(f).Decode(pf)
+	if err != nil {
+		return nil, err
+	}
+	return pf, nil
+}
+
+func removePidFile(dat string) {
+	os.Remove(dat + pidFileName) // Ignore error.
+}
+
+// Alive returns true if the recorded process is still running. If the process can't be checked to make sure it
+// is the one that was recorded, it is assumed to be something else using a reused PID.
+func (pf *PidFile) Alive() bool {
+	return processAlive(pf.PID, pf.DataPath, pf.Started)
+}
+
+// sameStart returns true if a process start time matches the one recorded in a PID file.
+func sameStart(start, recorded time.Time) bool {
+	d := start.Sub(recorded)
+	return d < pidStartSlack && d > -pidStartSlack
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "time"
+import "sort"
+import "regexp"
+import "encoding/json"
+
+// These match the lines the game logs when players connect and disconnect.
+var (
+	playerJoinRe  = regexp.MustCompile(`^(.+?) \[?([0-9a-fA-F:.]+?)\]?:[0-9]+ joins\.$`)
+	playerLeaveRe = regexp.MustCompile(`^Player (.+?) left\.$`)
+	playerDropRe  = regexp.MustCompile(`^Player (.+?) got removed\. Reason: (.*)$`)
+	playerUIDRe   = regexp.MustCompile(`^Client [0-9]+ uid (\S+) attempting identification\. Name: (.+)$`)
+)
+
+// PlayerInfo describes an online player.
+type PlayerInfo struct {
+	Name   string
+	UID    string // Empty if the server did not log it.
+	IP     string
+	Joined time.Time
+}
+
+// PlayerEvent is sent JSON encoded as the message of a PlayerClass log message whenever the online player list
+// for a server changes.
+type PlayerEvent struct {
+	Event  string // "join", "leave", "disconnect", "clear", or "list" (a reply to :players).
+	Name   string // The player who joined or left, empty for "clear".
+	Reason string // Why the player was disconnected, if known.
+	Online []*PlayerInfo
+}
+
+// Players returns the players currently online, sorted by name.
+func (sc *ServerController) Players() []*PlayerInfo {
+	sc.plmu.Lock()
+	defer sc.plmu.Unlock()
+
+	players := make([]*PlayerInfo, 0, len(sc.players))
+	for _, p := range sc.players {
+		pc := *p
+		players = append(players, &pc)
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].Name < players[j].Name })
+	return players
+}
+
+// trackPlayers updates the online player list from a server log message.
+func (sc *ServerController) trackPlayers(msg *LogMessage) {
+	if m := playerUIDRe.FindStringSubmatch(msg.Message); m != nil {
+		sc.plmu.Lock()
+		sc.uids[m[2]] = m[1]
+		sc.plmu.Unlock()
+		return
+	}
+	if m := playerJoinRe.FindStringSubmatch(msg.Message); m != nil {
+		sc.plmu.Lock()
+		p := &PlayerInfo{Name: m[1], UID: sc.uids[m[1]], IP: m[2], Joined: msg.At}
+		delete(sc.uids, m[1])
+		sc.players[p.Name] = p
+		sc.plmu.Unlock()
+		sc.playerEvent("join", p.Name, "")
+		return
+	}
+	if m := playerLeaveRe.FindStringSubmatch(msg.Message); m != nil {
+		if p := sc.removePlayer(m[1]); p != nil {
+			sc.endSession(p, msg.At, "")
+			sc.playerEvent("leave", m[1], "")
+		}
+		return
+	}
+	if m := playerDropRe.FindStringSubmatch(msg.Message); m != nil {
+		if p := sc.removePlayer(m[1]); p != nil {
+			sc.endSession(p, msg.At, m[2])
+			sc.playerEvent("disconnect", m[1], m[2])
+		}
+		return
+	}
+}
+
+func (sc *ServerController) removePlayer(name string) *PlayerInfo {
+	sc.plmu.Lock()
+	defer sc.plmu.Unlock()
+
+	p := sc.players[name]
+	delete(sc.players, name)
+	return p
+}
+
+// clearPlayers empties the online player list, used when the server goes down. Everyone's session is ended.
+func (sc *ServerController) clearPlayers() {
+	sc.plmu.Lock()
+	players := sc.players
+	sc.players = map[string]*PlayerInfo{}
+	sc.uids = map[string]string{}
+	sc.plmu.Unlock()
+
+	if len(players) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, p := range players {
+		sc.endSession(p, now, "Server down")
+	}
+	sc.playerEvent("clear", "", "")
+}
+
+func (sc *ServerController) playerEvent(event, name, reason string) {
+	sc.emit(sc.playerMessage(&PlayerEvent{Event: event, Name: name, Reason: reason}))
+}
+
+// playerMessage fills in the online player list and encodes a player event.
+func (sc *ServerController) playerMessage(ev *PlayerEvent) *LogMessage {
+	ev.Online = sc.Players()
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return &LogMessage{sc.sid, time.Now(), ErrorClass, err.Error()}
+	}
+	return &LogMessage{sc.sid, time.Now(), PlayerClass, string(b)}
+}
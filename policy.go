@@ -0,0 +1,92 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "fmt"
+import "time"
+
+// RestartPolicy controls what the monitor does when a server crashes.
+type RestartPolicy struct {
+	Never       bool // Never restart a crashed server, wait for :recover instead.
+	MaxRestarts int  // How many crashes are allowed within Window before the monitor gives up.
+	Window      int  // Seconds.
+	Delay       int  // Seconds to wait before restarting after the first crash. Doubles with each crash in Window.
+	MaxDelay    int  // Upper limit for the delay, in seconds.
+}
+
+// DefaultRestartPolicy is used for servers that do not have a policy of their own.
+var DefaultRestartPolicy = RestartPolicy{
+	Never:       false,
+	MaxRestarts: 3,
+	Window:      60,
+	Delay:       5,
+	MaxDelay:    300,
+}
+
+// Backoff records a crash at time at, and returns the updated crash list and how long to wait before
+// restarting the server. If the server should not be restarted ok will be false.
+func (p RestartPolicy) Backoff(crashes []time.Time, at time.Time) (out []time.Time, delay time.Duration, ok bool) {
+	if p.Never {
+		return nil, 0, false
+	}
+
+	window := time.Duration(p.Window) * time.Second
+	for _, t := range crashes {
+		if at.Sub(t) < window {
+			out = append(out, t)
+		}
+	}
+	out = append(out, at)
+	if len(out) > p.MaxRestarts {
+		return nil, 0, false
+	}
+
+	delay = time.Duration(p.Delay) * time.Second
+	max := time.Duration(p.MaxDelay) * time.Second
+	for i := 1; i < len(out) && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return out, delay, true
+}
+
+func (p RestartPolicy) String() string {
+	if p.Never {
+		return "Crashed servers are never restarted automatically."
+	}
+	return fmt.Sprintf("Up to %v restarts in %vs, waiting %vs after the first crash and doubling up to %vs.",
+		p.MaxRestarts, p.Window, p.Delay, p.MaxDelay)
+}
+
+// Policy returns the restart policy for this server.
+func (sd *ServerConfig) Policy() RestartPolicy {
+	sd.RLock()
+	defer sd.RUnlock()
+
+	if sd.Restart == nil {
+		return DefaultRestartPolicy
+	}
+	return *sd.Restart
+}
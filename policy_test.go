@@ -0,0 +1,77 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "time"
+import "testing"
+
+func TestBackoff(t *testing.T) {
+	policy := RestartPolicy{MaxRestarts: 5, Window: 600, Delay: 5, MaxDelay: 30}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+
+	tests := []struct {
+		name    string
+		policy  RestartPolicy
+		crashes []time.Time
+		at      time.Time
+		delay   time.Duration
+		count   int // Crashes left in the returned list.
+		ok      bool
+	}{
+		{"first crash", policy, nil, at(0), 5 * time.Second, 1, true},
+		{"second crash doubles", policy, []time.Time{at(0)}, at(10), 10 * time.Second, 2, true},
+		{"third crash doubles again", policy, []time.Time{at(0), at(10)}, at(20), 20 * time.Second, 3, true},
+		{"capped at MaxDelay", policy, []time.Time{at(0), at(10), at(20)}, at(30), 30 * time.Second, 4, true},
+		{"stays capped", policy, []time.Time{at(0), at(10), at(20), at(30)}, at(40), 30 * time.Second, 5, true},
+		{"too many crashes", policy, []time.Time{at(0), at(10), at(20), at(30), at(40)}, at(50), 0, 0, false},
+		{"old crashes forgotten", policy, []time.Time{at(0), at(10), at(20)}, at(615), 10 * time.Second, 2, true},
+		{"all crashes forgotten", policy, []time.Time{at(0), at(10)}, at(1000), 5 * time.Second, 1, true},
+		{"delay above max", RestartPolicy{MaxRestarts: 3, Window: 60, Delay: 100, MaxDelay: 30}, nil, at(0), 30 * time.Second, 1, true},
+		{"never", RestartPolicy{Never: true, MaxRestarts: 3, Window: 60, Delay: 5, MaxDelay: 30}, nil, at(0), 0, 0, false},
+	}
+	for _, test := range tests {
+		out, delay, ok := test.policy.Backoff(test.crashes, test.at)
+		if ok != test.ok || delay != test.delay || len(out) != test.count {
+			t.Errorf("%v: got (%v crashes, %v, %v), expected (%v crashes, %v, %v)", test.name, len(out), delay, ok, test.count, test.delay, test.ok)
+		}
+	}
+}
+
+func TestBackoffManyCrashes(t *testing.T) {
+	// Lots of crashes inside the window should never push the delay past MaxDelay.
+	policy := RestartPolicy{MaxRestarts: 1000, Window: 3600, Delay: 5, MaxDelay: 300}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var crashes []time.Time
+	for i := 0; i < 200; i++ {
+		var delay time.Duration
+		var ok bool
+		crashes, delay, ok = policy.Backoff(crashes, start.Add(time.Duration(i)*time.Second))
+		if !ok {
+			t.Fatalf("crash %v: restart refused", i)
+		}
+		if delay <= 0 || delay > 300*time.Second {
+			t.Fatalf("crash %v: delay %v out of range", i, delay)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "os"
+import "time"
+import "testing"
+
+func TestProcessAlive(t *testing.T) {
+	// The test binary has only just started, so it stands in for a server started now.
+	pid := os.Getpid()
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		pid     int
+		dat     string
+		started time.Time
+		ok      bool
+	}{
+		{"running", pid, os.Args[0], now, true},
+		{"no PID", 0, os.Args[0], now, false},
+		{"not running", 1 << 30, os.Args[0], now, false},
+		{"other data path", pid, "/no/such/server/data", now, false},
+		{"started earlier", pid, os.Args[0], now.Add(-time.Hour), false},
+		{"started later", pid, os.Args[0], now.Add(time.Hour), false},
+	}
+	for _, test := range tests {
+		if ok := processAlive(test.pid, test.dat, test.started); ok != test.ok {
+			t.Errorf("%v: got %v, expected %v", test.name, ok, test.ok)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+//go:build !windows
+
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "time"
+import "bytes"
+import "syscall"
+import "strconv"
+import "strings"
+import "io/ioutil"
+
+// clockTicks is the unit process start times are given in by /proc/<pid>/stat. This is USER_HZ, which is 100
+// on every architecture Linux supports.
+const clockTicks = 100
+
+// processAlive returns true if there is a running process with the given PID that is the server started at the
+// given time. The process command line must contain dat and its start time must match, so a reused PID is not
+// mistaken for a server. This needs the Linux /proc file system, without it no process can be verified and
+// processAlive always returns false.
+func processAlive(pid int, dat string, started time.Time) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	if err != nil && err != syscall.EPERM {
+		return false
+	}
+
+	cmdline, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+	if err != nil || !bytes.Contains(cmdline, []byte(dat)) {
+		return false
+	}
+	start, ok := processStart(pid)
+	return ok && sameStart(start, started)
+}
+
+// processStart returns when a process was started.
+func processStart(pid int) (time.Time, bool) {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	// The command name (the second field) is in parentheses, and may contain spaces or parentheses itself.
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return time.Time{}, false
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return time.Time{}, false
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64) // Field 22, clock ticks after boot.
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	boot, ok := bootTime()
+	if !ok {
+		return time.Time{}, false
+	}
+	return boot.Add(time.Duration(ticks) * (time.Second / clockTicks)), true
+}
+
+// bootTime returns when the system was booted.
+func bootTime() (time.Time, bool) {
+	stat, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, line := range strings.Split(string(stat), "\n") {
+		if !strings.HasPrefix(line, "btime ") {
+			continue
+		}
+		secs, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "btime ")), 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(secs, 0), true
+	}
+	return time.Time{}, false
+}
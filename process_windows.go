@@ -0,0 +1,54 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "time"
+import "syscall"
+
+const stillActive = 259
+
+// processAlive returns true if there is a running process with the given PID that was started at the given time,
+// so a reused PID is not mistaken for a server.
+func processAlive(pid int, dat string, started time.Time) bool {
+	if pid <= 0 {
+		return false
+	}
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	err = syscall.GetExitCodeProcess(h, &code)
+	if err != nil || code != stillActive {
+		return false
+	}
+
+	var created, exited, kernel, user syscall.Filetime
+	err = syscall.GetProcessTimes(h, &created, &exited, &kernel, &user)
+	if err != nil {
+		return false
+	}
+	return sameStart(time.Unix(0, created.Nanoseconds()), started)
+}
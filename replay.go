@@ -0,0 +1,161 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "time"
+import "encoding/json"
+
+const (
+	defaultReplayDepth = 200
+	defaultPageSize    = 100
+	maxPageSize        = 1000
+)
+
+// logRing holds the most recent messages for a server, so they can be replayed to new clients.
+type logRing struct {
+	msgs  []*LogMessage
+	next  int
+	count int
+}
+
+func newLogRing(depth int) *logRing {
+	return &logRing{msgs: make([]*LogMessage, depth)}
+}
+
+func (r *logRing) Add(msg *LogMessage) {
+	r.msgs[r.next] = msg
+	r.next = (r.next + 1) % len(r.msgs)
+	if r.count < len(r.msgs) {
+		r.count++
+	}
+}
+
+// Messages returns the messages in the ring, oldest first. It is safe to call on a nil ring.
+func (r *logRing) Messages() []*LogMessage {
+	if r == nil {
+		return nil
+	}
+	out := make([]*LogMessage, 0, r.count)
+	start := (r.next - r.count + len(r.msgs)) % len(r.msgs)
+	for i := 0; i < r.count; i++ {
+		out = append(out, r.msgs[(start+i)%len(r.msgs)])
+	}
+	return out
+}
+
+// logCursor keeps track of how far back a client has read a server's history. The client has everything logged
+// after Before, and the last Skip messages logged at exactly Before.
+type logCursor struct {
+	Before time.Time
+	Skip   int
+}
+
+// newCursor returns a cursor that starts just before the given messages.
+func newCursor(msgs []*LogMessage) *logCursor {
+	cur := &logCursor{Before: time.Now()}
+	for _, msg := range msgs {
+		switch {
+		case msg.At.Before(cur.Before):
+			cur.Before = msg.At
+			cur.Skip = 1
+		case msg.At.Equal(cur.Before):
+			cur.Skip++
+		}
+	}
+	return cur
+}
+
+// HistoryPage reads up to n archived messages for a server from before the cursor, oldest first, and moves the
+// cursor back past them.
+func HistoryPage(sid int, cur *logCursor, n int) ([]*LogMessage, error) {
+	segments, err := archiveSegments(sid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Work back from the newest segment until there are enough messages.
+	page := []*LogMessage{}
+	older := 0
+	for i := len(segments) - 1; i >= 0 && older < n; i-- {
+		if start, ok := segmentStart(segments[i]); ok && start.After(cur.Before) {
+			continue
+		}
+
+		msgs := []*LogMessage{}
+		err := readSegment(segments[i], func(msg *LogMessage) {
+			if msg.At.After(cur.Before) {
+				return
+			}
+			if msg.At.Before(cur.Before) {
+				older++
+			}
+			msgs = append(msgs, msg)
+		})
+		if err != nil {
+			return nil, err
+		}
+		page = append(msgs, page...)
+	}
+
+	// Drop the messages the client already has.
+	skip := cur.Skip
+	for i := len(page) - 1; i >= 0 && skip > 0; i-- {
+		if page[i].At.Equal(cur.Before) {
+			page = append(page[:i], page[i+1:]...)
+			skip--
+		}
+	}
+	if len(page) > n {
+		page = page[len(page)-n:]
+	}
+	if len(page) == 0 {
+		return page, nil
+	}
+
+	next := newCursor(page)
+	if next.Before.Equal(cur.Before) {
+		next.Skip += cur.Skip
+	}
+	*cur = *next
+	return page, nil
+}
+
+// historyMessage packs a list of old messages into a single HistoryClass message.
+func historyMessage(sid int, msgs []*LogMessage) *LogMessage {
+	b, err := json.Marshal(msgs)
+	if err != nil {
+		return &LogMessage{sid, time.Now(), ErrorClass, err.Error()}
+	}
+	return &LogMessage{sid, time.Now(), HistoryClass, string(b)}
+}
+
+// replayDepth returns how many recent messages to keep for each server.
+func (c *MonitorConfig) replayDepth() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.ReplayDepth == 0 {
+		return defaultReplayDepth
+	}
+	return c.ReplayDepth
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "fmt"
+import "time"
+import "errors"
+import "strings"
+
+// maxMissedCount keeps a schedule that fires every minute from taking forever to count missed runs.
+const maxMissedCount = 10000
+
+// ScheduledJob is a command that is run on a schedule. Commands starting with ":" are monitor commands,
+// anything else is sent to the server. Jobs run with the permissions of the user who created them.
+type ScheduledJob struct {
+	ID      int
+	SID     int
+	Spec    string // See Schedule for the format.
+	Command string
+	Owner   string // The name of the user who created the job.
+
+	LastRun time.Time
+	Missed  int // How many runs were missed because the monitor was not running.
+}
+
+func (j *ScheduledJob) String() string {
+	missed := ""
+	if j.Missed > 0 {
+		missed = fmt.Sprintf(", missed %v runs", j.Missed)
+	}
+	last := "never"
+	if !j.LastRun.IsZero() {
+		last = j.LastRun.Format("2006-01-02 15:04")
+	}
+	return fmt.Sprintf("%v: [SID %v] %q %q (by %v, last run %v%v)", j.ID, j.SID, j.Spec, j.Command, j.Owner, last, missed)
+}
+
+// jobCommandError is returned for commands that may not be run as scheduled jobs.
+var jobCommandError = errors.New("User management commands cannot be scheduled.")
+
+// jobServerError is returned when adding a job for something that isn't a server, such as the monitor console.
+// Jobs for SIDs that aren't servers would be dropped by the scheduler before they ever ran.
+var jobServerError = errors.New("Jobs can only be added from a server's tab.")
+
+// checkJobCommand returns an error if a command may not be run as a scheduled job. Job output is not
+// sent to anyone, so commands whose only purpose is their reply (like creating tokens) are not allowed.
+func checkJobCommand(command string) error {
+	if !strings.HasPrefix(command, ":") {
+		return nil
+	}
+	parts := parseCommand([]byte(command))
+	if len(parts) > 0 && parts[0] == ":user" {
+		return jobCommandError
+	}
+	return nil
+}
+
+// AddJob adds a new scheduled job and returns it. The SID must be an existing server.
+func (c *MonitorConfig) AddJob(sid int, spec, command, owner string) (*ScheduledJob, error) {
+	_, err := ParseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+	err = checkJobCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	if _, ok := c.Servers[sid]; !ok {
+		c.Unlock()
+		return nil, jobServerError
+	}
+	c.LastJobID++
+	job := &ScheduledJob{
+		ID:      c.LastJobID,
+		SID:     sid,
+		Spec:    spec,
+		Command: command,
+		Owner:   owner,
+		LastRun: time.Now(),
+	}
+	c.Schedule = append(c.Schedule, job)
+	c.Unlock()
+	return job, nil
+}
+
+// RemoveJob removes a scheduled job. Returns false if there is no such job.
+func (c *MonitorConfig) RemoveJob(id int) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	for i, job := range c.Schedule {
+		if job.ID == id {
+			c.Schedule = append(c.Schedule[:i], c.Schedule[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// removeServerJobs removes every job for the given server. The caller must hold the config write lock.
+// Returns true if any jobs were removed.
+func (c *MonitorConfig) removeServerJobs(sid int) bool {
+	keep := c.Schedule[:0]
+	for _, job := range c.Schedule {
+		if job.SID != sid {
+			keep = append(keep, job)
+		}
+	}
+	removed := len(keep) != len(c.Schedule)
+	for i := len(keep); i < len(c.Schedule); i++ {
+		c.Schedule[i] = nil
+	}
+	c.Schedule = keep
+	return removed
+}
+
+// jobScheduler runs scheduled jobs. It never returns.
+func (c *MonitorConfig) jobScheduler() {
+	c.checkMissedJobs()
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		due := []ScheduledJob{}
+		c.Lock()
+		// Jobs for deleted servers are dropped, otherwise they would keep firing forever.
+		gone := map[int]bool{}
+		for _, job := range c.Schedule {
+			if _, ok := c.Servers[job.SID]; !ok {
+				gone[job.SID] = true
+			}
+		}
+		for sid := range gone {
+			c.removeServerJobs(sid)
+		}
+		dropped := len(gone) > 0
+		for _, job := range c.Schedule {
+			sched, err := ParseSchedule(job.Spec)
+			if err != nil {
+				continue
+			}
+			next := sched.Next(job.LastRun)
+			if next.IsZero() || now.Before(next) {
+				continue
+			}
+			job.LastRun = now
+			due = append(due, *job)
+		}
+		c.Unlock()
+
+		if len(due) == 0 {
+			if dropped {
+				c.Dump()
+			}
+			continue
+		}
+		c.Dump()
+		for _, job := range due {
+			go c.runJob(job)
+		}
+	}
+}
+
+// checkMissedJobs reports any runs that should have happened while the monitor was not running. Missed runs
+// are not run late, since running something like a nightly restart in the middle of the day is worse than not
+// running it at all.
+func (c *MonitorConfig) checkMissedJobs() {
+	now := time.Now()
+	missed := []ScheduledJob{}
+	c.Lock()
+	for _, job := range c.Schedule {
+		sched, err := ParseSchedule(job.Spec)
+		if err != nil {
+			continue
+		}
+		count := 0
+		for t := sched.Next(job.LastRun); !t.IsZero() && t.Before(now) && count < maxMissedCount; t = sched.Next(t) {
+			count++
+		}
+		if count == 0 {
+			continue
+		}
+		job.Missed += count
+		job.LastRun = now
+		m := *job
+		m.Missed = count
+		missed = append(missed, m)
+	}
+	c.Unlock()
+
+	if len(missed) == 0 {
+		return
+	}
+	c.Dump()
+	for _, job := range missed {
+		c.jobLog(job.SID, ErrorClass, "Scheduled job %v (%q) missed %v runs while the monitor was down.", job.ID, job.Command, job.Missed)
+	}
+}
+
+// runJob runs a scheduled job as the user that created it.
+func (c *MonitorConfig) runJob(job ScheduledJob) {
+	c.RLock()
+	var usr *MonitorUser
+	for _, u := range c.Tokens {
+		if u.Name == job.Owner {
+			usr = u
+			break
+		}
+	}
+	authorized := usr != nil && (usr.IsAdmin || usr.Servers[job.SID])
+	c.RUnlock()
+	if !authorized {
+		c.jobLog(job.SID, ErrorClass, "Scheduled job %v not run, %v is no longer authorized for this server.", job.ID, job.Owner)
+		return
+	}
+
+	if err := checkJobCommand(job.Command); err != nil {
+		c.jobLog(job.SID, ErrorClass, "Scheduled job %v not run: %v", job.ID, err)
+		return
+	}
+
+	// The command's replies are discarded, only this summary is logged.
+	c.jobLog(job.SID, MonitorClass, "Running scheduled job %v: %v", job.ID, job.Command)
+	GlobalSockets.runCommand(nil, usr, job.SID, job.Command)
+}
+
+// jobLog reports something about a job on its server's log, or to the monitor console if the server is gone.
+func (c *MonitorConfig) jobLog(sid int, class string, f string, v ...interface{}) {
+	c.RLock()
+	sc, ok := c.LaunchedHandlers[sid]
+	c.RUnlock()
+	msg := &LogMessage{sid, time.Now(), class, fmt.Sprintf(f, v...)}
+	if ok && sc.emit(msg) {
+		return
+	}
+	GlobalSockets.Broadcast(msg)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "testing"
+
+func TestAddJob(t *testing.T) {
+	c := &MonitorConfig{Servers: map[int]*ServerConfig{1: {SID: 1, Name: "One"}}}
+
+	tests := []struct {
+		name    string
+		sid     int
+		spec    string
+		command string
+		ok      bool
+		err     error // The expected error, if it isn't from parsing the schedule.
+	}{
+		{"server command", 1, "@every 2h", "/announce hi", true, nil},
+		{"monitor command", 1, "0 5 * * *", ":restart", true, nil},
+		{"user command", 1, "@daily", ":user create \"bob\"", false, jobCommandError},
+		{"monitor console", 0, "@daily", ":backup create", false, jobServerError},
+		{"deleted server", 2, "@daily", "/announce hi", false, jobServerError},
+		{"bad schedule", 1, "whenever", "/announce hi", false, nil},
+	}
+	for _, test := range tests {
+		before := len(c.Schedule)
+		job, err := c.AddJob(test.sid, test.spec, test.command, "admin")
+		if (err == nil) != test.ok || (test.err != nil && err != test.err) {
+			t.Errorf("%v: got error %v, expected %v", test.name, err, test.err)
+		}
+		want := 0
+		if err == nil {
+			want = 1
+		}
+		if added := len(c.Schedule) - before; added != want {
+			t.Errorf("%v: %v jobs added, expected %v", test.name, added, want)
+		}
+		if err == nil && (job.SID != test.sid || job.Command != test.command || job.Owner != "admin") {
+			t.Errorf("%v: got job %v", test.name, job)
+		}
+	}
+}
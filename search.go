@@ -0,0 +1,124 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "time"
+import "regexp"
+import "strconv"
+import "strings"
+import "encoding/json"
+
+// maxSearchResults keeps a careless search from flooding a client.
+const maxSearchResults = 1000
+
+// logQuery describes what a log search is looking for. Zero times and an empty class match anything.
+type logQuery struct {
+	re    *regexp.Regexp
+	since time.Time
+	until time.Time
+	class string
+}
+
+func (q *logQuery) match(msg *LogMessage) bool {
+	if !q.since.IsZero() && msg.At.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && msg.At.After(q.until) {
+		return false
+	}
+	if q.class != "" && !strings.EqualFold(msg.Class, q.class) {
+		return false
+	}
+	return q.re.MatchString(msg.Message)
+}
+
+// parseSearchTime parses a time for a log search. This may be a date ("2006-01-02"), a date and time
+// ("2006-01-02 15:04" or RFC3339), or how long ago ("90m", "12h", "7d").
+func parseSearchTime(v string) (time.Time, bool) {
+	for _, f := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05"} {
+		t, err := time.ParseInLocation(f, v, time.Local)
+		if err == nil {
+			return t, true
+		}
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err == nil {
+		return t, true
+	}
+	if strings.HasSuffix(v, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err == nil && days >= 0 {
+			return time.Now().AddDate(0, 0, -days), true
+		}
+		return time.Time{}, false
+	}
+	d, err := time.ParseDuration(v)
+	if err == nil && d >= 0 {
+		return time.Now().Add(-d), true
+	}
+	return time.Time{}, false
+}
+
+// SearchLogs calls fn for every archived message from the given server that matches the query, oldest first.
+// Stops early if fn returns false.
+func SearchLogs(sid int, q *logQuery, fn func(msg *LogMessage) bool) error {
+	segments, err := archiveSegments(sid)
+	if err != nil {
+		return err
+	}
+
+	for i, seg := range segments {
+		// Segments are named for their first message, so that can be used to skip most of them.
+		if start, ok := segmentStart(seg); ok && !q.until.IsZero() && start.After(q.until) {
+			break
+		}
+		if i+1 < len(segments) && !q.since.IsZero() {
+			if next, ok := segmentStart(segments[i+1]); ok && next.Before(q.since) {
+				continue
+			}
+		}
+
+		more := true
+		err := readSegment(seg, func(msg *LogMessage) {
+			if more && q.match(msg) {
+				more = fn(msg)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+	return nil
+}
+
+// searchMessage wraps a search result so clients can show it apart from live messages.
+func searchMessage(sid int, msg *LogMessage) *LogMessage {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return &LogMessage{sid, time.Now(), ErrorClass, err.Error()}
+	}
+	return &LogMessage{sid, time.Now(), SearchClass, string(b)}
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "strconv"
+import "strings"
+import "net/url"
+import "net/http"
+import "encoding/hex"
+import "crypto/rand"
+
+import "golang.org/x/crypto/bcrypt"
+
+const (
+	sessionCookie    = "VSMonitorSession"
+	sessionLifetime  = 7 * 24 * time.Hour
+	sessionSweep     = time.Hour // How often expired sessions and old login failures are cleaned up.
+	minPasswordLen   = 8
+	passwordHashCost = bcrypt.DefaultCost
+
+	loginFreeFailures = 5           // Failed logins allowed before logins are throttled.
+	loginBackoff      = time.Minute // The first wait, this doubles with each failure after that.
+	loginMaxBackoff   = time.Hour
+)
+
+// dummyHash is checked when someone tries to log in as a user that doesn't exist (or has no password), so that
+// failing takes as long as it does for a real user.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), passwordHashCost)
+
+var GlobalSessions = &Sessions{
+	sessions: map[string]*webSession{},
+	failures: map[string]*loginFailures{},
+}
+
+// Sessions holds the login sessions created by the web UI login form, along with recent failed logins so
+// password guessing can be slowed down. Everything is only kept in memory, so restarting the monitor logs
+// everyone out.
+type Sessions struct {
+	sync.Mutex
+
+	sessions map[string]*webSession
+	failures map[string]*loginFailures // By client IP ("ip:<addr>") and user name ("user:<name>").
+}
+
+type webSession struct {
+	user    *MonitorUser
+	expires time.Time
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+	until time.Time // No logins are allowed before this.
+}
+
+// Create starts a new session for a user and returns its ID.
+func (ss *Sessions) Create(usr *MonitorUser) (string, time.Time, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	id := hex.EncodeToString(b)
+	expires := time.Now().Add(sessionLifetime)
+
+	ss.Lock()
+	defer ss.Unlock()
+
+	ss.sessions[id] = &webSession{usr, expires}
+	return id, expires, nil
+}
+
+// Lookup returns the user for a session, or nil if the session doesn't exist or has expired.
+func (ss *Sessions) Lookup(id string) *MonitorUser {
+	ss.Lock()
+	defer ss.Unlock()
+
+	session, ok := ss.sessions[id]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.expires) {
+		delete(ss.sessions, id)
+		return nil
+	}
+	return session.user
+}
+
+// Delete ends a session.
+func (ss *Sessions) Delete(id string) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	delete(ss.sessions, id)
+}
+
+// Revoke ends every session for a user.
+func (ss *Sessions) Revoke(usr *MonitorUser) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	for id, session := range ss.sessions {
+		if session.user == usr {
+			delete(ss.sessions, id)
+		}
+	}
+}
+
+// LoginWait returns how long the given clients must wait before trying to log in again, or 0 if they may try now.
+func (ss *Sessions) LoginWait(keys ...string) time.Duration {
+	ss.Lock()
+	defer ss.Unlock()
+
+	wait := time.Duration(0)
+	now := time.Now()
+	for _, key := range keys {
+		if f, ok := ss.failures[key]; ok && f.until.After(now) && f.until.Sub(now) > wait {
+			wait = f.until.Sub(now)
+		}
+	}
+	return wait
+}
+
+// LoginFailed records a failed login. After loginFreeFailures failures each client has to wait before trying
+// again, starting at loginBackoff and doubling each time up to loginMaxBackoff.
+func (ss *Sessions) LoginFailed(keys ...string) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		f, ok := ss.failures[key]
+		if !ok {
+			f = &loginFailures{}
+			ss.failures[key] = f
+		}
+		f.count++
+		f.last = now
+		if f.count < loginFreeFailures {
+			continue
+		}
+		wait := loginBackoff
+		for i := loginFreeFailures; i < f.count && wait < loginMaxBackoff; i++ {
+			wait *= 2
+		}
+		if wait > loginMaxBackoff {
+			wait = loginMaxBackoff
+		}
+		f.until = now.Add(wait)
+	}
+}
+
+// LoginSucceeded forgets any failed logins for the given clients.
+func (ss *Sessions) LoginSucceeded(keys ...string) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	for _, key := range keys {
+		delete(ss.failures, key)
+	}
+}
+
+// Sweep removes expired sessions, and failed logins old enough that they no longer matter.
+func (ss *Sessions) Sweep() {
+	ss.Lock()
+	defer ss.Unlock()
+
+	now := time.Now()
+	for id, session := range ss.sessions {
+		if now.After(session.expires) {
+			delete(ss.sessions, id)
+		}
+	}
+	for key, f := range ss.failures {
+		if now.After(f.until) && now.Sub(f.last) > loginMaxBackoff {
+			delete(ss.failures, key)
+		}
+	}
+}
+
+// sweeper calls Sweep every sessionSweep. It never returns.
+func (ss *Sessions) sweeper() {
+	ticker := time.NewTicker(sessionSweep)
+	defer ticker.Stop()
+	for range ticker.C {
+		ss.Sweep()
+	}
+}
+
+// loginKeys returns the keys failed logins are tracked under for a request.
+func loginKeys(r *http.Request, name string) []string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return []string{"ip:" + host, "user:" + strings.ToLower(name)}
+}
+
+// hashPassword returns the bcrypt hash of a password, for MonitorUser.Password.
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLen {
+		return "", fmt.Errorf("Passwords must be at least %v characters long.", minPasswordLen)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	return string(hash), err
+}
+
+// checkPassword returns the named user if the password is correct, or nil.
+func (c *MonitorConfig) checkPassword(name, password string) *MonitorUser {
+	c.RLock()
+	var usr *MonitorUser
+	hash := dummyHash
+	if key := c.tokenKey(name); key != "" && c.Tokens[key].Password != "" {
+		usr = c.Tokens[key]
+		hash = []byte(usr.Password)
+	}
+	c.RUnlock()
+
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if err != nil {
+		return nil
+	}
+	return usr
+}
+
+// sessionUser returns the user logged in with a session cookie, or nil. Since browsers send cookies along with
+// requests from any site, the request must come from a page served by the monitor.
+func sessionUser(r *http.Request) *MonitorUser {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return nil
+		}
+	}
+	return GlobalSessions.Lookup(cookie.Value)
+}
+
+// loginHandler handles the web UI login form. Takes "name" and "password" form values, and sets a session cookie
+// if they are correct.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.FormValue("name")
+	keys := loginKeys(r, name)
+	if wait := GlobalSessions.LoginWait(keys...); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+		http.Error(w, "Too many failed logins, try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	usr := GlobalConfig.checkPassword(name, r.FormValue("password"))
+	if usr == nil {
+		GlobalSessions.LoginFailed(keys...)
+		http.Error(w, "Invalid name or password.", http.StatusUnauthorized)
+		return
+	}
+	GlobalSessions.LoginSucceeded(keys...)
+
+	id, expires, err := GlobalSessions.Create(usr)
+	if err != nil {
+		fmt.Println("Error:", err)
+		http.Error(w, "Could not create session.", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logoutHandler ends the current session, if any.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		GlobalSessions.Delete(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
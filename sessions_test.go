@@ -0,0 +1,120 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "time"
+import "testing"
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		wait     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{10, 32 * time.Minute},
+		{11, time.Hour}, // 64 minutes, capped.
+		{100, time.Hour},
+	}
+	for _, test := range tests {
+		ss := &Sessions{sessions: map[string]*webSession{}, failures: map[string]*loginFailures{}}
+		for i := 0; i < test.failures; i++ {
+			ss.LoginFailed("ip:127.0.0.1", "user:alice")
+		}
+
+		for _, key := range []string{"ip:127.0.0.1", "user:alice"} {
+			wait := ss.LoginWait(key)
+			if wait > test.wait || wait < test.wait-time.Second {
+				t.Errorf("%v failures: %v must wait %v, expected %v", test.failures, key, wait, test.wait)
+			}
+		}
+		if wait := ss.LoginWait("ip:127.0.0.2", "user:bob"); wait != 0 {
+			t.Errorf("%v failures: other clients must wait %v", test.failures, wait)
+		}
+
+		ss.LoginSucceeded("ip:127.0.0.1", "user:alice")
+		if wait := ss.LoginWait("ip:127.0.0.1", "user:alice"); wait != 0 {
+			t.Errorf("%v failures: must still wait %v after logging in", test.failures, wait)
+		}
+	}
+}
+
+func TestLoginWaitLongest(t *testing.T) {
+	ss := &Sessions{sessions: map[string]*webSession{}, failures: map[string]*loginFailures{}}
+
+	// Guessing passwords for many users from one address is throttled by address, and guessing one user's
+	// password from many addresses is throttled by user.
+	for i := 0; i < 6; i++ {
+		ss.LoginFailed("ip:127.0.0.1", "user:alice")
+	}
+	ss.LoginFailed("ip:127.0.0.2", "user:bob")
+
+	tests := []struct {
+		keys []string
+		wait time.Duration
+	}{
+		{[]string{"ip:127.0.0.1", "user:bob"}, 2 * time.Minute},
+		{[]string{"ip:127.0.0.2", "user:alice"}, 2 * time.Minute},
+		{[]string{"ip:127.0.0.2", "user:bob"}, 0},
+		{[]string{}, 0},
+	}
+	for _, test := range tests {
+		wait := ss.LoginWait(test.keys...)
+		if wait > test.wait || wait < test.wait-time.Second {
+			t.Errorf("%q: must wait %v, expected %v", test.keys, wait, test.wait)
+		}
+	}
+}
+
+func TestSessionsSweep(t *testing.T) {
+	now := time.Now()
+	usr := &MonitorUser{Name: "alice"}
+	ss := &Sessions{
+		sessions: map[string]*webSession{
+			"expired": {usr, now.Add(-time.Minute)},
+			"current": {usr, now.Add(time.Minute)},
+		},
+		failures: map[string]*loginFailures{
+			"ip:old":      {count: 20, last: now.Add(-2 * loginMaxBackoff), until: now.Add(-loginMaxBackoff)},
+			"ip:recent":   {count: 1, last: now.Add(-time.Minute)},
+			"ip:waiting":  {count: 20, last: now.Add(-2 * loginMaxBackoff), until: now.Add(time.Minute)},
+			"user:recent": {count: 6, last: now, until: now.Add(2 * time.Minute)},
+		},
+	}
+	ss.Sweep()
+
+	for id, ok := range map[string]bool{"expired": false, "current": true} {
+		if _, found := ss.sessions[id]; found != ok {
+			t.Errorf("session %v kept is %v, expected %v", id, found, ok)
+		}
+	}
+	for key, ok := range map[string]bool{"ip:old": false, "ip:recent": true, "ip:waiting": true, "user:recent": true} {
+		if _, found := ss.failures[key]; found != ok {
+			t.Errorf("failures for %v kept is %v, expected %v", key, found, ok)
+		}
+	}
+}
@@ -25,7 +25,9 @@ package main
 import "io"
 import "os"
 import "errors"
+import "strings"
 import "archive/tar"
+import "path/filepath"
 import "compress/gzip"
 
 var tarUnknownTypeErr = errors.New("Unknown record type in tar.gz file.")
@@ -74,3 +76,73 @@ func ExtractTar(r io.Reader, to string) error {
 	}
 	return nil
 }
+
+// CreateTarGz writes the contents of the from directory to w as a gzip compressed tar file. Files for
+// which skip returns true are left out, skip is given the path relative to from and may be nil.
+func CreateTarGz(w io.Writer, from string, skip func(string) bool) error {
+	gz := gzip.NewWriter(w)
+	err := CreateTar(gz, from, skip)
+	if err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+func CreateTar(w io.Writer, from string, skip func(string) bool) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if skip != nil && skip(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// ExtractTar only understands files and directories, so anything else is left out.
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if info.IsDir() && !strings.HasSuffix(hdr.Name, "/") {
+			hdr.Name += "/"
+		}
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		// Only copy as much as the header says, in case the file grew since it was checked.
+		_, err = io.CopyN(tw, file, hdr.Size)
+		return err
+	})
+	if err != nil {
+		tw.Close()
+		return err
+	}
+	return tw.Close()
+}
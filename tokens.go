@@ -0,0 +1,111 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "fmt"
+import "strings"
+import "crypto/rand"
+import "crypto/sha256"
+import "crypto/subtle"
+import "encoding/hex"
+
+// Tokens are never stored, only a salted hash of each one, in the form "salt$hash" (both hex encoded). This
+// means a leaked config file doesn't leak working tokens, but it also means a token can only be shown once, when
+// it is created.
+
+// newToken generates a new random API token.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%X", b), nil
+}
+
+// hashToken returns a freshly salted hash of a token, suitable for use as a key in MonitorConfig.Tokens.
+func hashToken(token string) (string, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	return saltedHash(hex.EncodeToString(salt), token), nil
+}
+
+func saltedHash(salt, token string) string {
+	sum := sha256.Sum256([]byte(salt + token))
+	return salt + "$" + hex.EncodeToString(sum[:])
+}
+
+// userForToken finds the user a token belongs to, or returns nil. The caller must hold at least a read lock.
+func (c *MonitorConfig) userForToken(token string) *MonitorUser {
+	if token == "" {
+		return nil
+	}
+	var found *MonitorUser
+	for key, usr := range c.Tokens {
+		i := strings.IndexByte(key, '$')
+		if i < 0 {
+			continue
+		}
+		// Check every user, so the time taken doesn't say anything about which one matched.
+		if subtle.ConstantTimeCompare([]byte(saltedHash(key[:i], token)), []byte(key)) == 1 {
+			found = usr
+		}
+	}
+	return found
+}
+
+// tokenKey returns the Tokens key for the named user, or "" if there is no such user. The caller must hold at
+// least a read lock.
+func (c *MonitorConfig) tokenKey(name string) string {
+	for key, usr := range c.Tokens {
+		if usr.Name == name {
+			return key
+		}
+	}
+	return ""
+}
+
+// migrateTokens hashes any tokens saved by older versions of the monitor, which stored them in the clear. Returns
+// true if anything was changed, in which case the config should be saved.
+func (c *MonitorConfig) migrateTokens() (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	changed := false
+	for key, usr := range c.Tokens {
+		if strings.Contains(key, "$") {
+			continue
+		}
+		hash, err := hashToken(key)
+		if err != nil {
+			return changed, err
+		}
+		delete(c.Tokens, key)
+		c.Tokens[hash] = usr
+		changed = true
+	}
+	return changed, nil
+}
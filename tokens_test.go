@@ -0,0 +1,133 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "strings"
+import "testing"
+
+func TestUserForToken(t *testing.T) {
+	alice := &MonitorUser{Name: "alice"}
+	bob := &MonitorUser{Name: "bob"}
+	c := &MonitorConfig{Tokens: map[string]*MonitorUser{}}
+	for token, usr := range map[string]*MonitorUser{"ALICE": alice, "BOB": bob} {
+		key, err := hashToken(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Tokens[key] = usr
+	}
+	c.Tokens["PLAIN"] = bob // Not migrated, so never matches.
+
+	tests := []struct {
+		token string
+		usr   *MonitorUser
+	}{
+		{"ALICE", alice},
+		{"BOB", bob},
+		{"", nil},
+		{"alice", nil},
+		{"ALICE ", nil},
+		{"PLAIN", nil},
+		{"CAROL", nil},
+	}
+	for _, test := range tests {
+		if usr := c.userForToken(test.token); usr != test.usr {
+			t.Errorf("%q: got %v, expected %v", test.token, usr, test.usr)
+		}
+	}
+
+	// The hash is the only thing stored, so a key must not match itself.
+	for key := range c.Tokens {
+		if usr := c.userForToken(key); usr != nil {
+			t.Errorf("key %q matched %v", key, usr.Name)
+		}
+	}
+}
+
+func TestHashTokenSalted(t *testing.T) {
+	a, err := hashToken("TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := hashToken("TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Errorf("two hashes of the same token are both %q", a)
+	}
+	if strings.Contains(a, "TOKEN") {
+		t.Errorf("hash %q contains the token", a)
+	}
+}
+
+func TestMigrateTokens(t *testing.T) {
+	alice := &MonitorUser{Name: "alice"}
+	bob := &MonitorUser{Name: "bob"}
+	hashed, err := hashToken("BOB")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		tokens  map[string]*MonitorUser
+		changed bool
+		valid   map[string]*MonitorUser // Tokens that should work after migrating.
+	}{
+		{"empty", map[string]*MonitorUser{}, false, map[string]*MonitorUser{}},
+		{"already hashed", map[string]*MonitorUser{hashed: bob}, false, map[string]*MonitorUser{"BOB": bob}},
+		{"plain", map[string]*MonitorUser{"ALICE": alice}, true, map[string]*MonitorUser{"ALICE": alice}},
+		{"mixed", map[string]*MonitorUser{"ALICE": alice, hashed: bob}, true,
+			map[string]*MonitorUser{"ALICE": alice, "BOB": bob}},
+	}
+	for _, test := range tests {
+		tokens := map[string]*MonitorUser{}
+		for key, usr := range test.tokens {
+			tokens[key] = usr
+		}
+		c := &MonitorConfig{Tokens: tokens}
+
+		changed, err := c.migrateTokens()
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if changed != test.changed {
+			t.Errorf("%v: changed is %v, expected %v", test.name, changed, test.changed)
+		}
+		if len(c.Tokens) != len(test.tokens) {
+			t.Errorf("%v: %v tokens after migrating, expected %v", test.name, len(c.Tokens), len(test.tokens))
+		}
+		for key := range c.Tokens {
+			if !strings.Contains(key, "$") {
+				t.Errorf("%v: %q was not hashed", test.name, key)
+			}
+		}
+		for token, usr := range test.valid {
+			if got := c.userForToken(token); got != usr {
+				t.Errorf("%v: %q is for %v, expected %v", test.name, token, got, usr)
+			}
+		}
+	}
+}
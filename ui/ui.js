@@ -44,8 +44,10 @@ $(document).ready(function() {
 	}
 
 	function makeTab(sid, name) {
-		// If tab already exists in the list, return
+		// If tab already exists in the list, clear it and return. This happens when reconnecting, and the
+		// monitor will resend the recent history.
 		if ($(`#${sid}`).length != 0) {
+			$(`#content #${sid} .output`).empty()
 			return
 		}
 		
@@ -63,6 +65,61 @@ $(document).ready(function() {
 		$(`#${sid}`).show()
 	}
 
+	function formatState(st) {
+		if (st.State == "running") {
+			return `Server is running (PID ${st.PID}).`
+		}
+		if (st.Signal != "") {
+			return `Server ${st.State} (PID ${st.PID}, signal: ${st.Signal}).`
+		}
+		return `Server ${st.State} (PID ${st.PID}, exit code: ${st.ExitCode}).`
+	}
+
+	function formatPlayers(ev) {
+		var names = ev.Online.map(function(p) { return p.Name }).join(", ")
+		var online = `${ev.Online.length} online${ev.Online.length > 0 ? ": " + names : ""}`
+		switch (ev.Event) {
+		case "join":
+			return `${ev.Name} joined (${online}).`
+		case "leave":
+			return `${ev.Name} left (${online}).`
+		case "disconnect":
+			return `${ev.Name} was disconnected: ${ev.Reason} (${online}).`
+		case "clear":
+			return `Server down, all players gone.`
+		}
+		return `Players ${online}.`
+	}
+
+	function formatLine(msg) {
+		if (msg.Class == "Monitor State") {
+			msg.Message = formatState(JSON.parse(msg.Message))
+		}
+		if (msg.Class == "Monitor Players") {
+			msg.Message = formatPlayers(JSON.parse(msg.Message))
+		}
+
+		if (msg.Class == "Monitor Search") {
+			var found = JSON.parse(msg.Message)
+			var at = new Date(found.At).toLocaleString()
+			return `<div><span class="log-time">${at}</span> <span class="log-class log-Monitor-Search">[SID ${found.SID}] [${found.Class}]:</span> <span class="log-message">${found.Message}</span></div>`
+		}
+
+		var at = new Date(msg.At).toLocaleTimeString()
+		return `<div><span class="log-time">${at}</span> <span class="log-class log-${msg.Class.replace(" ", "-")}">[${msg.Class}]:</span> <span class="log-message">${msg.Message}</span></div>`
+	}
+
+	function removeTab(sid) {
+		var current = $(`#tabs a#${sid}`).parent().hasClass("current")
+		$(`#tabs a#${sid}`).parent().remove()
+		$(`#content div#${sid}`).remove()
+
+		// If the removed tab was selected, fall back to the monitor tab.
+		if (current) {
+			$("#tabs a#0").click()
+		}
+	}
+
 	$('#tabs').on('click', "a.tab", function() {
 		// Get the tab name
 		var sid = $(this).attr("id")
@@ -87,7 +144,7 @@ $(document).ready(function() {
 	$('#content').on('click', "input.enter", function() {
 		var sid = $(this).parents("div.tab").attr("id")
 		var el = $(`#content #${sid} input.commandline`)[0]
-		Conn.send(`{"SID": ${sid}, "Token": "${Settings.Token}", "Command": ${JSON.stringify(el.value)}}`)
+		Conn.send(JSON.stringify({SID: parseInt(sid), Command: el.value}))
 		cmdhistory.push(el.value)
 		el.value = ""
 	})
@@ -96,7 +153,7 @@ $(document).ready(function() {
 		var sid = $(this).parents("div.tab").attr("id")
 		if (evnt.which == 13) {
 			var el = $(`#content #${sid} input.commandline`)[0]
-			Conn.send(`{"SID": ${sid}, "Token": "${Settings.Token}", "Command": ${JSON.stringify(el.value)}}`)
+			Conn.send(JSON.stringify({SID: parseInt(sid), Command: el.value}))
 			cmdhistory.push(el.value)
 			el.value = ""
 		} else if (evnt.which == 38) {
@@ -116,25 +173,40 @@ $(document).ready(function() {
 			makeTab(msg.SID, msg.Message)
 			return
 		}
-
-		msg.At = new Date(msg.At).toLocaleTimeString()
+		if (msg.Class == "Monitor Remove") {
+			removeTab(msg.SID)
+			return
+		}
 
 		var el = $(`#content #${msg.SID} .output`)
 		if (el.length == 0) {
 			return
 		}
 
-		el.append(`<div><span class="log-time">${msg.At}</span> <span class="log-class log-${msg.Class.replace(" ", "-")}">[${msg.Class}]:</span> <span class="log-message">${msg.Message}</span></div>`)
-		
 		// Don't autoscroll if the user isn't at the bottom.
 		var at = el.scrollTop() + el.innerHeight()
-		el = el[0]
-		if (at >= el.scrollHeight - 60) {
-			el.scrollTop = el.scrollHeight
+		var bottom = at >= el[0].scrollHeight - 60
+
+		if (msg.Class == "Monitor History") {
+			// Older messages go above everything else.
+			var lines = JSON.parse(msg.Message).map(formatLine).join("")
+			var height = el[0].scrollHeight
+			el.prepend(lines)
+			if (bottom) {
+				el[0].scrollTop = el[0].scrollHeight
+			} else {
+				el[0].scrollTop += el[0].scrollHeight - height
+			}
+			return
+		}
+
+		el.append(formatLine(msg))
+		if (bottom) {
+			el[0].scrollTop = el[0].scrollHeight
 		}
 	}
 	Conn.onopen = function(evnt) {
-		Conn.send(`{"SID": 0, "Token": "${Settings.Token}", "Command": ":auth"}`)
+		Conn.send(JSON.stringify({SID: 0, Token: Settings.Token}))
 	}
 
 	$("#SettingsBox").hide()
@@ -147,11 +219,41 @@ $(document).ready(function() {
 		evnt.stopPropagation()
 		$("#SettingsBox").hide()
 	})
+	$("#sLogin").click(function(evnt) {
+		evnt.stopPropagation()
+		$.ajax({
+			url: "/login",
+			method: "POST",
+			data: {name: $("#sName")[0].value, password: $("#sPassword")[0].value},
+			success: function() {
+				$("#sPassword")[0].value = ""
+				$("#SettingsBox").hide()
+				Conn.refresh()
+			},
+			error: function(xhr) {
+				alert(xhr.responseText)
+			}
+		})
+	})
+	$("#sLogout").click(function(evnt) {
+		evnt.stopPropagation()
+		$.ajax({
+			url: "/logout",
+			method: "POST",
+			complete: function() {
+				$("#SettingsBox").hide()
+				Conn.refresh()
+			}
+		})
+	})
 	$("#sOK").click(function(evnt) {
 		evnt.stopPropagation()
 		Settings.Token = $("#sToken")[0].value
 		window.localStorage["VS.ServerMonitor.Settings"] = JSON.stringify(Settings)
 		$("#SettingsBox").hide()
+
+		// The token is only sent when connecting, so reconnect to log in with the new one.
+		Conn.refresh()
 	})
 
 	$("#HelpPanel").hide()
@@ -26,7 +26,10 @@ package main
 import "os"
 import "fmt"
 import "mime"
+import "sort"
+import "time"
 import "net/http"
+import "syscall"
 import "os/signal"
 import "crypto/tls"
 import "encoding/json"
@@ -46,16 +49,24 @@ TODO:
 */
 
 func main() {
+	// The monitor runs itself to apply resource limits to a server before it starts, see prepareLimits.
+	if len(os.Args) > 1 && os.Args[1] == limitsHelperArg {
+		limitsHelper(os.Args[2:])
+	}
+
 	cfgf, err := os.Open("./Monitor/cfg.json")
 	if err != nil {
 		// Create default configuration, print message, and exit.
 		cfg := &MonitorConfig{
 			ServerDir:        baseDir() + "/Binaries",
 			DataDir:          baseDir() + "/GameData",
+			BackupDir:        baseDir() + "/Backups",
 			LastSID:          0,
 			HostName:         "localhost",
 			Port:             "2660",
 			AutoTLS:          false,
+			StopTimeout:      60,
+			AutoStartDelay:   10,
 			Servers:          make(map[int]*ServerConfig),
 			Versions:         make(map[string]BinaryStatus),
 			Tokens:           make(map[string]*MonitorUser),
@@ -99,7 +110,28 @@ func main() {
 		os.Exit(1)
 	}
 
+	err = cfg.checkWatchdogs()
+	if err != nil {
+		fmt.Println("Invalid watchdog settings in config file:", err)
+		os.Exit(1)
+	}
+
+	// Tokens used to be saved as-is, make sure they are hashed.
+	migrated, err := cfg.migrateTokens()
+	if err != nil {
+		fmt.Println("Could not hash API tokens:", err)
+		os.Exit(1)
+	}
+	if migrated {
+		err = cfg.Dump()
+		if err != nil {
+			fmt.Println("Could not save config file:", err)
+			os.Exit(1)
+		}
+	}
+
 	GlobalConfig = cfg
+	GlobalSockets.SetReplayDepth(cfg.replayDepth())
 
 	// Spin up controllers for each defined server.
 	cfg.Lock()
@@ -107,14 +139,70 @@ func main() {
 		cfg.LaunchedHandlers[sid] = cfg.NewServerController(sid)
 	}
 	cfg.Unlock()
+	go cfg.autoStart()
+	go cfg.backupScheduler()
+	go cfg.jobScheduler()
+	go GlobalSessions.sweeper()
 
 	// Start the web UI server. This doesn't really interact with the monitor in any way except
 	// for pointing web socket connection in the right general direction.
 	go webUI(cfg.HostName, cfg.Port, cfg.AutoTLS)
 
-	exitSignal := make(chan os.Signal)
-	signal.Notify(exitSignal, os.Interrupt, os.Kill)
+	exitSignal := make(chan os.Signal, 1)
+	signal.Notify(exitSignal, os.Interrupt, syscall.SIGTERM)
 	<-exitSignal
+
+	// A second signal skips the clean shutdown.
+	go func() {
+		<-exitSignal
+		fmt.Println("Exiting without stopping servers.")
+		os.Exit(1)
+	}()
+
+	fmt.Println("Stopping servers...")
+	cfg.Shutdown()
+	err = cfg.Dump()
+	if err != nil {
+		fmt.Println("Could not save config file:", err)
+		os.Exit(1)
+	}
+}
+
+// autoStart starts every server that has AutoStart set, in SID order, waiting AutoStartDelay seconds
+// between each one so they don't all try to load their worlds at the same time.
+func (c *MonitorConfig) autoStart() {
+	c.RLock()
+	delay := time.Duration(c.AutoStartDelay) * time.Second
+	sids := []int{}
+	for sid, sd := range c.Servers {
+		sd.RLock()
+		if sd.AutoStart {
+			sids = append(sids, sid)
+		}
+		sd.RUnlock()
+	}
+	c.RUnlock()
+	sort.Ints(sids)
+
+	for i, sid := range sids {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+
+		c.RLock()
+		sc, ok := c.LaunchedHandlers[sid]
+		c.RUnlock()
+		if !ok {
+			continue
+		}
+		if !sc.WaitReady(time.Minute) {
+			sc.log("Could not autostart server, the controller did not start in time.")
+			continue
+		}
+		if !sc.Start() {
+			sc.log("Could not autostart server, it is already running or the controller has exited.")
+		}
+	}
 }
 
 func webUI(host, port string, autotls bool) {
@@ -122,6 +210,8 @@ func webUI(host, port string, autotls bool) {
 	FS.Mount("", sources.NewOSDir(baseDir()+"/Monitor/ui"), false)
 
 	http.HandleFunc("/socket", GlobalSockets.Upgrade)
+	http.HandleFunc("/login", loginHandler)
+	http.HandleFunc("/logout", logoutHandler)
 
 	// Basic UI server.
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
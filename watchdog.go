@@ -0,0 +1,139 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "fmt"
+import "time"
+import "errors"
+import "sync/atomic"
+
+// WatchdogConfig controls the hang detector for a server. When enabled, a server that has been silent for
+// Interval seconds is sent the Probe command, and a server that stays silent for Timeout seconds is killed
+// and restarted according to its restart policy.
+type WatchdogConfig struct {
+	Enabled  bool
+	Interval int
+	Timeout  int
+	Probe    string
+}
+
+// DefaultWatchdog holds the settings used for a server that does not have a watchdog configured.
+var DefaultWatchdog = WatchdogConfig{
+	Enabled:  false,
+	Interval: 60,
+	Timeout:  300,
+	Probe:    "/stats",
+}
+
+var watchdogValueError = errors.New("Watchdog interval and timeout must be greater than zero.")
+var watchdogIntervalError = errors.New("Watchdog interval must be less than the timeout, or the server would be killed before it is probed.")
+
+// validate returns an error if the watchdog settings make no sense. This is checked even if the watchdog is
+// disabled, so it can't be turned on later with bad settings.
+func (wd WatchdogConfig) validate() error {
+	if wd.Interval <= 0 || wd.Timeout <= 0 {
+		return watchdogValueError
+	}
+	if wd.Interval >= wd.Timeout {
+		return watchdogIntervalError
+	}
+	return nil
+}
+
+func (wd WatchdogConfig) String() string {
+	if !wd.Enabled {
+		return "Watchdog is disabled."
+	}
+	return fmt.Sprintf("Watchdog is enabled, probing with %q after %vs of silence and restarting after %vs.",
+		wd.Probe, wd.Interval, wd.Timeout)
+}
+
+// WatchdogConfig returns the watchdog settings for this server.
+func (sd *ServerConfig) WatchdogConfig() WatchdogConfig {
+	sd.RLock()
+	defer sd.RUnlock()
+
+	if sd.Watchdog == nil {
+		return DefaultWatchdog
+	}
+	return *sd.Watchdog
+}
+
+// checkWatchdogs validates the watchdog settings for every server.
+func (c *MonitorConfig) checkWatchdogs() error {
+	c.RLock()
+	defer c.RUnlock()
+
+	for sid, sd := range c.Servers {
+		err := sd.WatchdogConfig().validate()
+		if err != nil {
+			return fmt.Errorf("SID %v: %v", sid, err)
+		}
+	}
+	return nil
+}
+
+// touch records that the server just produced some output.
+func (sc *ServerController) touch() {
+	atomic.StoreInt64(sc.lastout, time.Now().UnixNano())
+}
+
+// silence returns how long it has been since the server produced any output.
+func (sc *ServerController) silence() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(sc.lastout)))
+}
+
+// watchdog probes a quiet server, and reports on sc.hung if it stays quiet too long. Exits when quit is closed.
+func (sc *ServerController) watchdog(wd WatchdogConfig, quit chan bool) {
+	interval := time.Duration(wd.Interval) * time.Second
+	timeout := time.Duration(wd.Timeout) * time.Second
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	lastprobe := time.Now()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			silent := sc.silence()
+			if silent >= timeout {
+				select {
+				case sc.hung <- silent:
+				case <-quit:
+				}
+				return
+			}
+			if silent >= interval && time.Since(lastprobe) >= interval {
+				lastprobe = time.Now()
+
+				// Don't block, if the command stuffer is stuck that is a good sign the server is hung anyway.
+				select {
+				case sc.cmds <- wd.Probe:
+				default:
+				}
+			}
+		}
+	}
+}
@@ -30,8 +30,16 @@ import "net/http"
 
 import "github.com/gorilla/websocket"
 
+const (
+	clientQueueSize = 256              // Messages waiting to be written to a client. A client that falls this far behind is dropped.
+	writeWait       = 10 * time.Second // How long a single write may take.
+	pongWait        = 60 * time.Second // How long to wait for a client to answer a ping.
+	pingPeriod      = pongWait * 9 / 10
+)
+
 var GlobalSockets = &Sockets{
-	clients:  map[*websocket.Conn]bool{},
+	clients:  map[*websocket.Conn]*socketClient{},
+	recent:   map[int]*logRing{},
 	Messages: make(chan *SocketMessage),
 }
 
@@ -46,43 +54,298 @@ type Sockets struct {
 	sync.Mutex
 
 	// Used for broadcast.
-	clients map[*websocket.Conn]bool
+	clients map[*websocket.Conn]*socketClient
+
+	// Recent messages for each server, replayed to new clients.
+	recent map[int]*logRing
+	depth  int
 
 	Messages chan *SocketMessage
 }
 
+// socketClient holds the state for a single connection. Messages are written by a separate goroutine, so a slow
+// client can't hold up anyone else.
+type socketClient struct {
+	queue chan *LogMessage
+	done  chan struct{} // Closed when the client is dropped.
+
+	user    *MonitorUser
+	admin   bool         // Copied from user, so broadcasts don't need to lock the config.
+	servers map[int]bool // Ditto.
+
+	cursors map[int]*logCursor // How far back :log more has gone for each server.
+	muted   map[int]bool       // Servers the client has unsubscribed from.
+	filters map[int]*logFilter // Filters for each server, if any.
+}
+
+// SetReplayDepth sets how many recent messages are kept for each server. Zero or less disables replay.
+func (s *Sockets) SetReplayDepth(depth int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.depth = depth
+	s.recent = map[int]*logRing{}
+}
+
 func (s *Sockets) Broadcast(msg *LogMessage) {
 	s.Lock()
 	defer s.Unlock()
 
-	for conn := range s.clients {
-		err := conn.WriteJSON(msg)
-		if err != nil {
-			fmt.Println("Socket closed:", err)
-			conn.Close()
-			delete(s.clients, conn)
+	s.remember(msg)
+	for conn, client := range s.clients {
+		if client.allowed(msg.SID) && client.wants(msg) {
+			s.send(conn, msg)
 		}
 	}
 }
 
+// SendTo sends a message to a single client. If conn is nil the message is discarded, this is used for
+// the output of commands that were not run by a client (scheduled jobs, for example). Command replies may
+// contain things like new tokens, so they must never be broadcast.
+//
+// Unlike Broadcast, SendTo waits for room in the client's queue, so large replies (search results, for example)
+// don't get the client dropped. A client that can't take the message within writeWait is dropped anyway.
 func (s *Sockets) SendTo(conn *websocket.Conn, msg *LogMessage) {
+	if conn == nil {
+		return
+	}
+
+	s.Lock()
+	client, ok := s.clients[conn]
+	s.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case client.queue <- msg:
+	case <-client.done:
+	case <-time.After(writeWait):
+		fmt.Println("Socket closed: client too slow")
+		s.drop(conn)
+	}
+}
+
+// send queues a message for a client, dropping the client if its queue is full. The caller must hold the lock.
+func (s *Sockets) send(conn *websocket.Conn, msg *LogMessage) {
+	client, ok := s.clients[conn]
+	if !ok {
+		return
+	}
+	select {
+	case client.queue <- msg:
+	default:
+		fmt.Println("Socket closed: client too slow")
+		s.dropLocked(conn)
+	}
+}
+
+// drop disconnects a client.
+func (s *Sockets) drop(conn *websocket.Conn) {
 	s.Lock()
 	defer s.Unlock()
 
-	err := conn.WriteJSON(msg)
-	if err != nil {
-		fmt.Println("Socket closed:", err)
+	s.dropLocked(conn)
+}
+
+// dropLocked disconnects a client. The writer will close the connection once it notices. The caller must hold the
+// lock.
+func (s *Sockets) dropLocked(conn *websocket.Conn) {
+	client, ok := s.clients[conn]
+	if !ok {
+		return
+	}
+	delete(s.clients, conn)
+	close(client.done)
+}
+
+// writer writes queued messages to a client, and pings it to make sure it is still there.
+func (s *Sockets) writer(conn *websocket.Conn, client *socketClient) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
 		conn.Close()
-		delete(s.clients, conn)
+	}()
+
+	for {
+		select {
+		case msg := <-client.queue:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err := conn.WriteJSON(msg)
+			if err != nil {
+				fmt.Println("Socket closed:", err)
+				s.drop(conn)
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err := conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				fmt.Println("Socket closed:", err)
+				s.drop(conn)
+				return
+			}
+		case <-client.done:
+			// Flush anything still queued (like the reason the client is being dropped), then say goodbye.
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			for len(client.queue) > 0 {
+				if conn.WriteJSON(<-client.queue) != nil {
+					return
+				}
+			}
+			conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+		}
 	}
 }
 
+// remember adds a message to the replay buffer for its server. The caller must hold the lock.
+func (s *Sockets) remember(msg *LogMessage) {
+	switch msg.Class {
+	case InitClass, HistoryClass:
+		return
+	case RemoveClass:
+		delete(s.recent, msg.SID)
+		return
+	}
+	if s.depth <= 0 {
+		return
+	}
+
+	r, ok := s.recent[msg.SID]
+	if !ok {
+		r = newLogRing(s.depth)
+		s.recent[msg.SID] = r
+	}
+	r.Add(msg)
+}
+
+// allowed returns true if the client's user may see messages from a server. Only admins can see the monitor
+// console (SID 0). The caller must hold the Sockets lock.
+func (c *socketClient) allowed(sid int) bool {
+	return c.admin || (sid != 0 && c.servers[sid])
+}
+
+// authorize copies a user's permissions to the client. The caller must hold a read lock on the config.
+func (c *socketClient) authorize(usr *MonitorUser) {
+	c.admin = usr.IsAdmin
+	c.servers = map[int]bool{}
+	for sid, ok := range usr.Servers {
+		c.servers[sid] = ok
+	}
+}
+
+// Reauthorize updates every connection for a user after the user's permissions have been changed. Clients are
+// sent init or remove messages for any servers they gained or lost access to. Must not be called with the config
+// locked.
+func (s *Sockets) Reauthorize(usr *MonitorUser) {
+	GlobalConfig.RLock()
+	defer GlobalConfig.RUnlock()
+	s.Lock()
+	defer s.Unlock()
+
+	for conn, client := range s.clients {
+		if client.user != usr {
+			continue
+		}
+		was := &socketClient{admin: client.admin, servers: client.servers}
+		client.authorize(usr)
+		for sid, sd := range GlobalConfig.Servers {
+			sd.RLock()
+			name := sd.Name
+			sd.RUnlock()
+			switch {
+			case client.allowed(sid) && !was.allowed(sid):
+				s.send(conn, &LogMessage{sid, time.Now(), InitClass, name})
+			case !client.allowed(sid) && was.allowed(sid):
+				s.send(conn, &LogMessage{sid, time.Now(), RemoveClass, name})
+			}
+		}
+	}
+}
+
+// Disconnect closes every connection for a user, used when the user is deleted or their token is revoked.
+func (s *Sockets) Disconnect(usr *MonitorUser) {
+	s.Lock()
+	defer s.Unlock()
+
+	for conn, client := range s.clients {
+		if client.user == usr {
+			s.dropLocked(conn)
+		}
+	}
+}
+
+// cursor returns the :log more cursor for a client and server.
+func (s *Sockets) cursor(conn *websocket.Conn, sid int) *logCursor {
+	s.Lock()
+	defer s.Unlock()
+
+	client, ok := s.clients[conn]
+	if !ok {
+		return newCursor(nil)
+	}
+	cur, ok := client.cursors[sid]
+	if !ok {
+		cur = newCursor(nil)
+		client.cursors[sid] = cur
+	}
+	return cur
+}
+
 type SocketMessage struct {
 	SID     int
-	Token   string
+	Token   string // Only used in the first message, to authenticate.
 	Command string
 }
 
+// rootUser is used for every connection while there are no user accounts, so the first account can be created.
+// Its sessions are closed as soon as an account exists.
+var rootUser = &MonitorUser{
+	Name:    "root",
+	IsAdmin: true,
+	Servers: make(map[int]bool),
+}
+
+// authenticate works out who is on the other end of a new connection. API clients may send their token in an
+// Authorization header ("Bearer <token>", other schemes are ignored), browsers that have logged in with a password
+// send a session cookie, otherwise the first message from the client must hold the token (any command in that
+// message is ignored).
+// Returns nil if the client could not be authenticated.
+func (s *Sockets) authenticate(conn *websocket.Conn, r *http.Request) *MonitorUser {
+	token := ""
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if token == "" {
+		if usr := sessionUser(r); usr != nil {
+			return usr
+		}
+
+		msg := new(SocketMessage)
+		err := conn.ReadJSON(&msg)
+		if err != nil {
+			fmt.Println("Socket closed:", err)
+			return nil
+		}
+		token = msg.Token
+	}
+
+	GlobalConfig.RLock()
+	hastokens := len(GlobalConfig.Tokens) > 0
+	usr := GlobalConfig.userForToken(token)
+	GlobalConfig.RUnlock()
+	if !hastokens {
+		s.SendTo(conn, &LogMessage{0, time.Now(), MonitorClass, "WARNING: There are no user accounts created yet! Create an account with the :user command."})
+		return rootUser
+	}
+	if usr == nil {
+		s.SendTo(conn, &LogMessage{0, time.Now(), ErrorClass, "Invalid token."})
+		return nil
+	}
+	return usr
+}
+
 func (s *Sockets) Upgrade(w http.ResponseWriter, r *http.Request) {
 	conn, err := wsUpgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -91,124 +354,199 @@ func (s *Sockets) Upgrade(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Start the writer. The client won't get any broadcasts until it is authorized.
+	client := &socketClient{
+		queue:   make(chan *LogMessage, clientQueueSize),
+		done:    make(chan struct{}),
+		cursors: map[int]*logCursor{},
+		muted:   map[int]bool{},
+		filters: map[int]*logFilter{},
+	}
+	s.Lock()
+	s.clients[conn] = client
+	s.Unlock()
+	go s.writer(conn, client)
+	defer s.drop(conn)
+
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
 	// Send the monitor console activation packet.
 	s.SendTo(conn, &LogMessage{0, time.Now(), InitClass, "Monitor"})
 
 	// Before sending anything else, make sure this is an authorized user.
-	msg := new(SocketMessage)
-	err = conn.ReadJSON(&msg)
-	if err != nil {
-		fmt.Println("Socket closed:", err)
-		conn.Close()
-		return
-	}
-	GlobalConfig.RLock()
-	hastokens := len(GlobalConfig.Tokens) > 0
-	usr, ok := GlobalConfig.Tokens[msg.Token]
-	GlobalConfig.RUnlock()
-	if !hastokens {
-		usr = &MonitorUser{
-			Name:    "root",
-			IsAdmin: true,
-			Servers: make(map[int]bool),
-		}
-		s.SendTo(conn, &LogMessage{msg.SID, time.Now(), MonitorClass, "WARNING: There are no user accounts created yet! Create an account with the :user command."})
-	} else if !ok {
-		s.SendTo(conn, &LogMessage{msg.SID, time.Now(), ErrorClass, "Invalid token."})
+	usr := s.authenticate(conn, r)
+	if usr == nil {
 		return
 	}
 
-	// Send activation packets for each authorized server.
+	// Send activation packets for each authorized server. SendTo can block, so the packets are collected
+	// first and sent after the config is unlocked.
 	GlobalConfig.RLock()
 	t := time.Now()
-	for sid := range GlobalConfig.LaunchedHandlers {
+	sids := []int{}
+	if usr.IsAdmin {
+		sids = append(sids, 0)
+	}
+	init := []*LogMessage{}
+	for sid, sc := range GlobalConfig.LaunchedHandlers {
 		if !usr.IsAdmin && !usr.Servers[sid] {
 			continue
 		}
+		sids = append(sids, sid)
 		sinfo := GlobalConfig.Servers[sid]
 		sinfo.RLock()
-		s.SendTo(conn, &LogMessage{sid, t, InitClass, sinfo.Name})
+		init = append(init, &LogMessage{sid, t, InitClass, sinfo.Name})
 		sinfo.RUnlock()
+
+		// And who is online, so clients don't have to wait for someone to join or leave.
+		if sc.IsUp() {
+			init = append(init, sc.playerMessage(&PlayerEvent{Event: "list"}))
+		}
 	}
 	GlobalConfig.RUnlock()
+	for _, msg := range init {
+		s.SendTo(conn, msg)
+	}
 
+	// Replay recent history, then start sending live messages. Doing both under the lock makes sure nothing
+	// is missed or sent twice.
+	GlobalConfig.RLock()
 	s.Lock()
-	s.clients[conn] = true
+	client.user = usr
+	client.authorize(usr)
+	GlobalConfig.RUnlock()
+	for _, sid := range sids {
+		msgs := s.recent[sid].Messages()
+		client.cursors[sid] = newCursor(msgs)
+		if len(msgs) > 0 {
+			s.send(conn, historyMessage(sid, msgs))
+		}
+	}
 	s.Unlock()
 
 	for {
+		// Commands run on this goroutine, and pongs aren't read while one is running, so a slow command (:backup
+		// create, for example) would otherwise use up the deadline and get the client dropped.
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
 		msg := new(SocketMessage)
 		err := conn.ReadJSON(&msg)
 		if err != nil {
 			fmt.Println("Socket closed:", err)
-			conn.Close()
-			s.Lock()
-			delete(s.clients, conn)
-			s.Unlock()
 			break
 		}
 
-		// Validate token.
-		GlobalConfig.RLock()
-		hastokens := len(GlobalConfig.Tokens) > 0
-		usr, ok := GlobalConfig.Tokens[msg.Token]
-		GlobalConfig.RUnlock()
-		if !hastokens {
-			usr = &MonitorUser{
-				Name:    "root",
-				IsAdmin: true,
-				Servers: make(map[int]bool),
-			}
-			s.SendTo(conn, &LogMessage{msg.SID, time.Now(), MonitorClass, "WARNING: There are no user accounts created yet! Create an account with :user."})
-		} else if !ok {
-			s.SendTo(conn, &LogMessage{msg.SID, time.Now(), ErrorClass, "Invalid token."})
+		if msg.Command == "" {
 			continue
 		}
 
-		if !usr.IsAdmin && !usr.Servers[msg.SID] {
+		// The user was authenticated when the connection was opened, but permissions may have changed since.
+		GlobalConfig.RLock()
+		ok := usr.IsAdmin || usr.Servers[msg.SID]
+		GlobalConfig.RUnlock()
+		if !ok {
 			s.SendTo(conn, &LogMessage{msg.SID, time.Now(), ErrorClass, "You are not authorized to send messages to that server."})
 			continue
 		}
 
-		if strings.HasPrefix(msg.Command, ":") {
-			// It is a monitor command.
-			parts := parseCommand([]byte(msg.Command))
-			if len(parts) == 0 {
-				// Basically impossible, or at least it should be.
-				continue
-			}
-			switch parts[0] {
-			case ":recover":
-				cmdRecover(conn, usr, parts, msg.SID)
-			case ":server":
-				cmdServer(conn, usr, parts, msg.SID)
-			case ":kill":
-				cmdKill(conn, usr, parts, msg.SID)
-			case ":user":
-				cmdUser(conn, usr, parts, msg.SID)
-			default:
-				t := time.Now()
-				s.SendTo(conn, &LogMessage{msg.SID, t, MonitorClass, ":recover"})
-				s.SendTo(conn, &LogMessage{msg.SID, t, MonitorClass, ":server create \"name\" [stable|unstable|<x.x.x.x>]"})
-				s.SendTo(conn, &LogMessage{msg.SID, t, MonitorClass, ":server update [<x.x.x.x>]"})
-				//s.SendTo(conn, &LogMessage{msg.SID, t, MonitorClass, ":server delete"})
-				s.SendTo(conn, &LogMessage{msg.SID, t, MonitorClass, ":kill (monitor|server)"})
-				s.SendTo(conn, &LogMessage{msg.SID, t, MonitorClass, ":user (create|delete) \"<name>\""})
-				s.SendTo(conn, &LogMessage{msg.SID, t, MonitorClass, ":user [authorize|deauthorize] \"<name>\" [<sid>|admin]"})
-			}
-			continue
-		}
+		s.runCommand(conn, usr, msg.SID, msg.Command)
+	}
+}
 
-		GlobalConfig.RLock()
-		sc, ok := GlobalConfig.LaunchedHandlers[msg.SID]
-		GlobalConfig.RUnlock()
-		if !ok {
-			s.SendTo(conn, &LogMessage{msg.SID, time.Now(), ErrorClass, "Could not send command to server, invalid SID."})
-			continue
+// runCommand runs a monitor command, or sends a command to a server. The user must already have been checked
+// to make sure they are authorized for the given SID. If conn is nil (for scheduled jobs), replies are discarded.
+func (s *Sockets) runCommand(conn *websocket.Conn, usr *MonitorUser, sid int, command string) {
+	if strings.HasPrefix(command, ":") {
+		// It is a monitor command.
+		parts := parseCommand([]byte(command))
+		if len(parts) == 0 {
+			// Basically impossible, or at least it should be.
+			return
 		}
-		if !sc.Command(msg.Command) {
-			s.SendTo(conn, &LogMessage{msg.SID, time.Now(), ErrorClass, "Could not send command to server, server not up."})
-			continue
+		switch parts[0] {
+		case ":recover":
+			cmdRecover(conn, usr, parts, sid)
+		case ":stop":
+			cmdStop(conn, usr, parts, sid)
+		case ":restart":
+			cmdRestart(conn, usr, parts, sid)
+		case ":server":
+			cmdServer(conn, usr, parts, sid)
+		case ":backup":
+			cmdBackup(conn, usr, parts, sid)
+		case ":players":
+			cmdPlayers(conn, usr, parts, sid)
+		case ":player":
+			cmdPlayer(conn, usr, parts, sid)
+		case ":log":
+			cmdLog(conn, usr, parts, sid)
+		case ":subscribe":
+			cmdSubscribe(conn, usr, parts, sid)
+		case ":filter":
+			cmdFilter(conn, usr, parts, sid)
+		case ":schedule":
+			cmdSchedule(conn, usr, parts, sid)
+		case ":kill":
+			cmdKill(conn, usr, parts, sid)
+		case ":user":
+			cmdUser(conn, usr, parts, sid)
+		default:
+			t := time.Now()
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":recover"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":stop"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":restart"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":restart in <duration>"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":restart cancel"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server create \"name\" [stable|unstable|<x.x.x.x>]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server update [<x.x.x.x>]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server autostart [on|off]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server policy [always|never|reset]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server policy (restarts|window|delay|maxdelay) <value>"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server watchdog [on|off|reset]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server watchdog (interval|timeout) <seconds>"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server watchdog probe \"<command>\""})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server launch [reset]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server launch (exe|dir) \"<value>\""})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server launch (args|env) [\"<value>\" ...]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server limits [reset]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server limits (nice|addressspace|openfiles|memory|cpu) <value>"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":server delete \"<name>\" [archive|remove]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":backup (create|list)"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":backup (restore|delete) <id>"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":backup schedule [\"<cron>\"|\"@every <duration>\"|off]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":backup keep (last|hourly|daily|weekly) <count>"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":players"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":player (history|seen) \"<name>\""})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":log more [<count>]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":log search \"<regex>\" [--since <time>] [--until <time>] [--class <class>]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":subscribe [(on|off) [<sid>|all]]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":filter [clear]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":filter class [\"<class>\" ...]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":filter match [\"<regex>\"]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":schedule add \"<cron>\" \"<command>\""})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":schedule list"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":schedule remove <id>"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":kill (monitor|server)"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":user (create|delete|rotate) \"<name>\""})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":user password \"<name>\" [\"<password>\"]"})
+			s.SendTo(conn, &LogMessage{sid, t, MonitorClass, ":user [authorize|deauthorize] \"<name>\" [<sid>|admin]"})
 		}
+		return
+	}
+
+	GlobalConfig.RLock()
+	sc, ok := GlobalConfig.LaunchedHandlers[sid]
+	GlobalConfig.RUnlock()
+	if !ok {
+		s.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not send command to server, invalid SID."})
+		return
+	}
+	if !sc.Command(command) {
+		s.SendTo(conn, &LogMessage{sid, time.Now(), ErrorClass, "Could not send command to server, server not up."})
+		return
 	}
 }
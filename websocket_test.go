@@ -0,0 +1,256 @@
+/*
+Copyright 2018 by Milo Christiansen
+
+This software is provided 'as-is', without any express or implied warranty. In
+no event will the authors be held liable for any damages arising from the use of
+this software.
+
+Permission is granted to anyone to use this software for any purpose, including
+commercial applications, and to alter it and redistribute it freely, subject to
+the following restrictions:
+
+1. The origin of this software must not be misrepresented; you must not claim
+that you wrote the original software. If you use this software in a product, an
+acknowledgment in the product documentation would be appreciated but is not
+required.
+
+2. Altered source versions must be plainly marked as such, and must not be
+misrepresented as being the original software.
+
+3. This notice may not be removed or altered from any source distribution.
+*/
+
+package main
+
+import "sort"
+import "strconv"
+import "time"
+import "strings"
+import "reflect"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+
+import "github.com/gorilla/websocket"
+
+func TestClientAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		admin   bool
+		servers map[int]bool
+		sid     int
+		ok      bool
+	}{
+		{"admin, monitor", true, nil, 0, true},
+		{"admin, server", true, nil, 5, true},
+		{"user, monitor", false, map[int]bool{0: true, 1: true}, 0, false},
+		{"user, authorized", false, map[int]bool{1: true}, 1, true},
+		{"user, other server", false, map[int]bool{1: true}, 2, false},
+		{"user, deauthorized", false, map[int]bool{1: false}, 1, false},
+		{"user, no servers", false, nil, 1, false},
+	}
+	for _, test := range tests {
+		client := &socketClient{admin: test.admin, servers: test.servers}
+		if ok := client.allowed(test.sid); ok != test.ok {
+			t.Errorf("%v: allowed(%v) is %v, expected %v", test.name, test.sid, ok, test.ok)
+		}
+	}
+}
+
+// addTestToken gives a user a token.
+func addTestToken(t *testing.T, c *MonitorConfig, token string, usr *MonitorUser) {
+	key, err := hashToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Tokens[key] = usr
+}
+
+// testClient is the client end of a socket connected to a test server.
+type testClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialTestClient(t *testing.T, srv *httptest.Server, token string) *testClient {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	c := &testClient{t, conn}
+	c.send(0, token, "")
+	c.sync() // Discard the activation packets.
+	return c
+}
+
+func (c *testClient) send(sid int, token, command string) {
+	err := c.conn.WriteJSON(&SocketMessage{SID: sid, Token: token, Command: command})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// sync returns every message sent to the client so far as sorted "Class SID" strings. It works by sending a
+// command to a server that doesn't exist, and reading until the error reply comes back.
+func (c *testClient) sync() []string {
+	c.send(99, "", "/help")
+	got := []string{}
+	for {
+		msg := &LogMessage{}
+		err := c.conn.ReadJSON(msg)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if msg.SID == 99 && msg.Class == ErrorClass {
+			break
+		}
+		got = append(got, msg.Class+" "+strconv.Itoa(msg.SID))
+	}
+	sort.Strings(got)
+	return got
+}
+
+func TestSocketAuthorization(t *testing.T) {
+	old := GlobalConfig
+	defer func() { GlobalConfig = old }()
+
+	usr := &MonitorUser{Name: "user", Servers: map[int]bool{1: true}}
+	admin := &MonitorUser{Name: "admin", IsAdmin: true, Servers: map[int]bool{}}
+	GlobalConfig = &MonitorConfig{
+		Servers: map[int]*ServerConfig{
+			1: {SID: 1, Name: "One"},
+			2: {SID: 2, Name: "Two"},
+			3: {SID: 3, Name: "Three"},
+		},
+		Tokens:           map[string]*MonitorUser{},
+		LaunchedHandlers: map[int]*ServerController{},
+	}
+	addTestToken(t, GlobalConfig, "USER", usr)
+	addTestToken(t, GlobalConfig, "ADMIN", admin)
+
+	s := &Sockets{
+		clients:  map[*websocket.Conn]*socketClient{},
+		recent:   map[int]*logRing{},
+		Messages: make(chan *SocketMessage),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer srv.Close()
+	uc := dialTestClient(t, srv, "USER")
+	defer uc.conn.Close()
+	ac := dialTestClient(t, srv, "ADMIN")
+	defer ac.conn.Close()
+
+	tests := []struct {
+		name  string
+		perms func() // Changes the user's permissions. If nil, a message is broadcast for each server instead.
+		user  []string
+		admin []string
+	}{
+		{"broadcast", nil,
+			[]string{"Monitor 1"},
+			[]string{"Monitor 0", "Monitor 1", "Monitor 2", "Monitor 3"}},
+		{"move to server 2", func() { usr.Servers = map[int]bool{2: true} },
+			[]string{"Monitor Init 2", "Monitor Remove 1"}, []string{}},
+		{"broadcast after move", nil,
+			[]string{"Monitor 2"},
+			[]string{"Monitor 0", "Monitor 1", "Monitor 2", "Monitor 3"}},
+		{"make admin", func() { usr.IsAdmin = true },
+			[]string{"Monitor Init 1", "Monitor Init 3"}, []string{}},
+		{"broadcast as admin", nil,
+			[]string{"Monitor 0", "Monitor 1", "Monitor 2", "Monitor 3"},
+			[]string{"Monitor 0", "Monitor 1", "Monitor 2", "Monitor 3"}},
+		{"remove admin", func() { usr.IsAdmin = false; usr.Servers = map[int]bool{} },
+			[]string{"Monitor Remove 1", "Monitor Remove 2", "Monitor Remove 3"}, []string{}},
+		{"broadcast with no servers", nil,
+			[]string{},
+			[]string{"Monitor 0", "Monitor 1", "Monitor 2", "Monitor 3"}},
+	}
+	for _, test := range tests {
+		if test.perms != nil {
+			GlobalConfig.Lock()
+			test.perms()
+			GlobalConfig.Unlock()
+			s.Reauthorize(usr)
+		} else {
+			for sid := 0; sid <= 3; sid++ {
+				s.Broadcast(&LogMessage{sid, time.Now(), MonitorClass, "test"})
+			}
+		}
+
+		if got := uc.sync(); !reflect.DeepEqual(got, test.user) {
+			t.Errorf("%v: user got %q, expected %q", test.name, got, test.user)
+		}
+		if got := ac.sync(); !reflect.DeepEqual(got, test.admin) {
+			t.Errorf("%v: admin got %q, expected %q", test.name, got, test.admin)
+		}
+	}
+}
+
+func TestSocketAuthenticate(t *testing.T) {
+	old := GlobalConfig
+	defer func() { GlobalConfig = old }()
+
+	GlobalConfig = &MonitorConfig{
+		Servers:          map[int]*ServerConfig{},
+		Tokens:           map[string]*MonitorUser{},
+		LaunchedHandlers: map[int]*ServerController{},
+	}
+	addTestToken(t, GlobalConfig, "TOKEN", &MonitorUser{Name: "user", Servers: map[int]bool{}})
+
+	s := &Sockets{
+		clients:  map[*websocket.Conn]*socketClient{},
+		recent:   map[int]*logRing{},
+		Messages: make(chan *SocketMessage),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(s.Upgrade))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		header string // The Authorization header, if any.
+		first  string // The token sent in the first message, if one is sent.
+		ok     bool
+	}{
+		{"bearer", "Bearer TOKEN", "", true},
+		{"bearer, bad token", "Bearer WRONG", "", false},
+		{"first message", "", "TOKEN", true},
+		{"first message, bad token", "", "WRONG", false},
+		{"basic, then first message", "Basic dXNlcjpwYXNz", "TOKEN", true},
+		{"no scheme, then first message", "TOKEN", "TOKEN", true},
+		{"empty bearer, then first message", "Bearer ", "TOKEN", true},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.header != "" {
+			header.Set("Authorization", test.header)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		if test.first != "" {
+			conn.WriteJSON(&SocketMessage{Token: test.first})
+		}
+
+		// Authorized clients get an error for a server that doesn't exist, anyone else is told their token is
+		// invalid and disconnected.
+		conn.WriteJSON(&SocketMessage{SID: 99, Command: "/help"})
+		ok := false
+		for {
+			msg := &LogMessage{}
+			if conn.ReadJSON(msg) != nil {
+				break
+			}
+			if msg.Class == ErrorClass {
+				ok = msg.SID == 99
+				break
+			}
+		}
+		conn.Close()
+		if ok != test.ok {
+			t.Errorf("%v: authorized is %v, expected %v", test.name, ok, test.ok)
+		}
+	}
+}